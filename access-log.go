@@ -0,0 +1,223 @@
+package websvc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	requestIDKey = "websvc.request_id"
+	sessionIDKey = "websvc.session_id"
+)
+
+// AccessLogEntry is one line of the access log
+type AccessLogEntry struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"request_id"`
+	SessionID string            `json:"session_id,omitempty"`
+	ClientIP  string            `json:"client_ip"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Route     string            `json:"route,omitempty"`
+	Query     string            `json:"query,omitempty"`
+	Status    int               `json:"status"`
+	LatencyMs float64           `json:"latency_ms"`
+	BytesIn   int64             `json:"bytes_in"`
+	BytesOut  int               `json:"bytes_out"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Errors    string            `json:"errors,omitempty"`
+}
+
+// AccessLogSink receives the access log entries
+type AccessLogSink interface {
+	WriteAccessLog(entry *AccessLogEntry)
+}
+
+// JSONAccessLogSink writes the entries as JSON lines
+type JSONAccessLogSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONAccessLogSink(w io.Writer) *JSONAccessLogSink {
+	return &JSONAccessLogSink{w: w}
+}
+
+func (s *JSONAccessLogSink) WriteAccessLog(entry *AccessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(line)
+}
+
+// LoggerAccessLogSink writes the entries as JSON through logger.Info, so they follow the log level
+type LoggerAccessLogSink struct{}
+
+func (LoggerAccessLogSink) WriteAccessLog(entry *AccessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	logger.Info("%s", line)
+}
+
+// DefaultAccessLogSink writes JSON lines to stdout
+var DefaultAccessLogSink AccessLogSink = NewJSONAccessLogSink(os.Stdout)
+
+// RequestID returns the ID of the request, assigned by RequestIDMiddleware or AccessLogMiddleware
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// SessionID returns the session ID of the request after a successful authentication
+func SessionID(c *gin.Context) string {
+	return c.GetString(sessionIDKey)
+}
+
+// RequestIDMiddleware takes the request ID from the X-Request-ID header, or generates one if it is missing or invalid,
+// and echoes it in the response header
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ensureRequestID(c)
+		c.Next()
+	}
+}
+
+func ensureRequestID(c *gin.Context) string {
+	if id := RequestID(c); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if !isValidRequestID(id) {
+		var b [16]byte
+		binary.BigEndian.PutUint64(b[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(b[8:], rand.Uint64())
+		id = hex.EncodeToString(b[:])
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// isValidRequestID accepts printable ASCII IDs up to 128 characters, so clients cannot inject into the logs
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogMiddleware writes an entry for each request to the sink, DefaultAccessLogSink is used if sink is nil.
+// The request ID is assigned as by RequestIDMiddleware.
+func AccessLogMiddleware(cfg *AccessLogConfig, sink AccessLogSink) gin.HandlerFunc {
+	if sink == nil {
+		sink = DefaultAccessLogSink
+	}
+	sampleRate := cfg.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	redactHeaders := []string{"Authorization", "Cookie"}
+	if cfg.RedactHeaders != nil {
+		redactHeaders = make([]string, 0, len(cfg.RedactHeaders))
+		for _, h := range cfg.RedactHeaders {
+			redactHeaders = append(redactHeaders, http.CanonicalHeaderKey(h))
+		}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := ensureRequestID(c)
+		path := c.Request.URL.Path
+		rawQuery := c.Request.URL.RawQuery
+
+		c.Next()
+
+		if slices.Contains(cfg.SkipPaths, path) {
+			return
+		}
+		status := c.Writer.Status()
+		if status < 400 && sampleRate < 1 && rand.Float64() >= sampleRate {
+			return
+		}
+
+		entry := &AccessLogEntry{
+			Time:      start,
+			RequestID: requestID,
+			SessionID: SessionID(c),
+			ClientIP:  c.ClientIP(),
+			Method:    c.Request.Method,
+			Path:      path,
+			Route:     c.FullPath(),
+			Query:     redactQuery(rawQuery, cfg.RedactQuery),
+			Status:    status,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			BytesIn:   c.Request.ContentLength,
+			BytesOut:  c.Writer.Size(),
+			UserAgent: c.Request.UserAgent(),
+			Errors:    c.Errors.String(),
+		}
+		if entry.BytesOut < 0 {
+			entry.BytesOut = 0
+		}
+		if entry.BytesIn < 0 {
+			entry.BytesIn = 0
+		}
+		if len(cfg.Headers) > 0 {
+			entry.Headers = make(map[string]string, len(cfg.Headers))
+			for _, h := range cfg.Headers {
+				v := c.Request.Header.Get(h)
+				if v == "" {
+					continue
+				}
+				if slices.Contains(redactHeaders, http.CanonicalHeaderKey(h)) {
+					v = "[REDACTED]"
+				}
+				entry.Headers[h] = v
+			}
+		}
+		sink.WriteAccessLog(entry)
+	}
+}
+
+func redactQuery(rawQuery string, keys []string) string {
+	if rawQuery == "" || len(keys) == 0 {
+		return rawQuery
+	}
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		k, _, _ := strings.Cut(part, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if slices.Contains(keys, k) {
+			parts[i] = url.QueryEscape(k) + "=%5BREDACTED%5D"
+		}
+	}
+	return strings.Join(parts, "&")
+}
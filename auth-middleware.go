@@ -0,0 +1,92 @@
+package websvc
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionKey  = "websvc.session"
+	authBodyKey = "websvc.auth_body" // Set if the signature covered the body, as by Auth rather than AuthN
+)
+
+// SessionFrom returns the session object of the request after a successful authentication,
+// false if the request is not authenticated or the session is not a TSES
+func SessionFrom[TSES interface{}](c *gin.Context) (TSES, bool) {
+	v, ok := c.Get(sessionKey)
+	if !ok {
+		var zero TSES
+		return zero, false
+	}
+	ses, ok := v.(TSES)
+	return ses, ok
+}
+
+// AuthMiddleware authenticates the requests as Auth does, so a route group, static files
+// or a WebSocketHandler upgrade can be protected in one place.
+// The session is stored in the context, see SessionFrom and SessionID, and the body consumed by the signature
+// check is restored for the handlers, which should be plain Handler* ones rather than Auth* again.
+//
+// In a group already authenticated by an outer AuthMiddleware, only the privilege is checked,
+// so nested groups can require their own privileges:
+//
+//	api := r.Group("/api", AuthMiddleware(cfg, ""))
+//	admin := api.Group("/admin", AuthMiddleware(cfg, "admin"))
+//
+// Behind an outer AuthNMiddleware the request is authenticated again, since its body was not signed.
+func AuthMiddleware(cfg *AuthenticatorConfigure, privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool(authBodyKey) {
+			requirePrivilege(c, cfg, privilege)
+			return
+		}
+
+		_, _, body_bytes, code := doAuth(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+		// The handlers only ever see the body the signature was verified for
+		c.Request.Body = io.NopCloser(bytes.NewReader(body_bytes))
+		c.Request.ContentLength = int64(len(body_bytes))
+		c.Request.TransferEncoding = nil
+		c.Next()
+	}
+}
+
+// AuthNMiddleware is AuthMiddleware for the clients signing as for AuthN, without the body
+func AuthNMiddleware(cfg *AuthenticatorConfigure, privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(sessionKey); ok {
+			requirePrivilege(c, cfg, privilege)
+			return
+		}
+
+		_, _, code := doAuthN(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requirePrivilege checks the privilege of the session authenticated by an outer middleware
+func requirePrivilege(c *gin.Context, cfg *AuthenticatorConfigure, privilege string) {
+	ses, _ := c.Get(sessionKey)
+	ctx, span := StartSpan(c, "websvc.auth")
+	code, reason := checkPrivilege(ctx, c, cfg, SessionID(c), ses, privilege)
+	if code == 0 {
+		reason = "authorized"
+	}
+	authResult(span, code, reason)
+	span.End()
+
+	if code != 0 {
+		c.AbortWithStatus(code)
+		return
+	}
+	c.Next()
+}
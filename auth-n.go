@@ -1,84 +1,79 @@
 package websvc
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"errors"
-	"strconv"
-	"strings"
+	"net/url"
 	"time"
 
 	"github.com/acsl-go/logger"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Do authentication without body data
 // The signature will be calculated with:
 //
-//	sha256(ses_id + ":" + token + ":" + timestamp + ":" + query)
-func doAuthN(c *gin.Context, cfg *AuthenticatorConfigure, privilege string) (interface{}, map[string]string, int) {
-	auth_str := c.GetHeader("Authorization")
-	if auth_str == "" {
-		return nil, nil, 401
-	}
-
-	auth_parts := strings.Split(strings.ReplaceAll(auth_str, "Bearer ", ""), ":")
-	if len(auth_parts) != 3 {
-		return nil, nil, 401
-	}
-
-	ses_id := auth_parts[0]
-	signature := auth_parts[1]
-	timestamp, e := strconv.ParseInt(auth_parts[2], 10, 64)
-	if e != nil {
-		return nil, nil, 401
+//	hmac_sha256(token, ses_id + ":" + timestamp + ":" + query)
+//
+// where query is the canonical query as for doAuth.
+// The v2 signature is the same as for doAuth, with the hash of an empty body.
+func doAuthN(c *gin.Context, cfg *AuthenticatorConfigure, privilege string) (interface{}, url.Values, int) {
+	ctx, span := StartSpan(c, "websvc.auth")
+	defer span.End()
+
+	auth, reason := parseAuthorization(c.GetHeader("Authorization"))
+	if auth == nil {
+		return nil, nil, authResult(span, 401, reason)
 	}
+	span.SetAttributes(attribute.Int("auth.version", auth.version))
 
-	ts_error := time.Now().UnixMilli() - timestamp
+	ses_id := auth.sesID
+	ts_error := time.Now().UnixMilli() - auth.ts
 	if ts_error > cfg.TsTolerance || ts_error < -cfg.TsTolerance {
-		return nil, nil, 400
+		return nil, nil, authResult(span, 400, "timestamp_out_of_range")
 	}
 
-	ses, token, e := cfg.QueryToken(c, ses_id)
+	var ses interface{}
+	var token string
+	e := traceCall(ctx, "websvc.auth.QueryToken", func() (err error) {
+		ses, token, err = cfg.QueryToken(c, ses_id)
+		return err
+	})
 	if e != nil {
 		logger.Error("QueryToken Error: %+v", e)
-		return nil, nil, 500
+		return nil, nil, authResult(span, 500, "query_token_error")
 	}
 
 	if ses == nil || token == "" {
-		return nil, nil, 401
+		return nil, nil, authResult(span, 401, "unknown_session")
 	}
 
-	queries, query, e := parseQuery(c)
-	if e != nil {
-		return nil, nil, 400
+	queries := parseQuery(c)
+
+	if reason := auth.verify(c, cfg, token, queries, nil, false); reason != "" {
+		return nil, nil, authResult(span, 401, reason)
 	}
 
-	sig_str := auth_parts[0] + ":" + token + ":" + auth_parts[2] + ":" + query
-	sig_hash := sha256.Sum256([]byte(sig_str))
-	if signature != hex.EncodeToString(sig_hash[:]) {
-		return nil, nil, 401
+	if code, reason := checkNonce(ctx, c, cfg, auth); code != 0 {
+		return nil, nil, authResult(span, code, reason)
 	}
 
-	if cfg.CheckPrivilege != nil && privilege != "" {
-		if e := cfg.CheckPrivilege(c, ses_id, ses, privilege); e != nil {
-			if errors.Is(e, ErrNoPrivilege) {
-				return nil, nil, 403
-			} else {
-				logger.Fatal("privilege checking failed: %+v", e)
-				return nil, nil, 500
-			}
-		}
+	if code, reason := checkPrivilege(ctx, c, cfg, ses_id, ses, privilege); code != 0 {
+		return nil, nil, authResult(span, code, reason)
 	}
 
 	if cfg.RefreshToken != nil {
-		if e := cfg.RefreshToken(c, ses_id, ses); e != nil {
+		e := traceCall(ctx, "websvc.auth.RefreshToken", func() error {
+			return cfg.RefreshToken(c, ses_id, ses)
+		})
+		if e != nil {
 			logger.Fatal("token refresh failed: %+v", e)
-			return nil, nil, 500
+			return nil, nil, authResult(span, 500, "refresh_token_error")
 		}
 	}
 
-	return ses, queries, 0
+	c.Set(sessionIDKey, ses_id)
+	c.Set(sessionKey, ses)
+	return ses, queries, authResult(span, 0, "authenticated")
 }
 
 func AuthN[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES) (int, interface{}, error), privilege string) gin.HandlerFunc {
@@ -98,8 +93,7 @@ func AuthN[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Cont
 
 		code, rsp, e := handler(c, ses)
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -122,13 +116,38 @@ func AuthQN[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Con
 			return
 		}
 
-		code, rsp, e := handler(c, ses, queries)
+		code, rsp, e := handler(c, ses, flattenQuery(queries))
 		if e != nil {
-			logger.Error("Error: %+v", e)
+			abortWithError(c, e)
+			return
+		}
+
+		processResp(c, code, rsp)
+	}
+}
+
+// AuthQNM is AuthQN with all the values of repeated query keys
+func AuthQNM[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES, url.Values) (int, interface{}, error), privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ses_obj, queries, code := doAuthN(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+
+		ses, ok := ses_obj.(TSES)
+		if !ok {
+			logger.Fatal("Auth data type miss-match in %s", c.Request.URL.Path)
 			c.AbortWithStatus(500)
 			return
 		}
 
+		code, rsp, e := handler(c, ses, queries)
+		if e != nil {
+			abortWithError(c, e)
+			return
+		}
+
 		processResp(c, code, rsp)
 	}
 }
@@ -0,0 +1,123 @@
+package websvc
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const authNonceKey = "websvc.auth_nonce"
+
+// The longest nonce accepted in the Authorization header
+const MaxNonceLength = 128
+
+// NonceStore remembers the nonces of signed requests, so a captured request cannot be replayed.
+// Implementations shared by several instances, e.g. on Redis with SET NX PX, protect a whole cluster.
+type NonceStore interface {
+	// Use marks the nonce as used for ttl, returns false if it has been used and not expired yet
+	Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+type nonceEntry struct {
+	nonce  string
+	expire time.Time
+}
+
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].expire.Before(h[j].expire) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(nonceEntry)) }
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// MemoryNonceStore is a NonceStore of a single instance, keeping at most capacity unexpired nonces.
+// When it is full, new nonces are refused with ErrNonceStoreFull rather than forgetting the old ones,
+// which would make them replayable.
+type MemoryNonceStore struct {
+	mu       sync.Mutex
+	capacity int
+	nonces   map[string]time.Time
+	expires  nonceHeap
+}
+
+// NewMemoryNonceStore creates a MemoryNonceStore, capacity should cover the signed requests of 2 * TsTolerance
+func NewMemoryNonceStore(capacity int) *MemoryNonceStore {
+	return &MemoryNonceStore{
+		capacity: capacity,
+		nonces:   make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryNonceStore) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.expires) > 0 && !s.expires[0].expire.After(now) {
+		e := heap.Pop(&s.expires).(nonceEntry)
+		delete(s.nonces, e.nonce)
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false, nil
+	}
+	if len(s.nonces) >= s.capacity {
+		return false, ErrNonceStoreFull
+	}
+	expire := now.Add(ttl)
+	s.nonces[nonce] = expire
+	heap.Push(&s.expires, nonceEntry{nonce: nonce, expire: expire})
+	return true, nil
+}
+
+// checkNonce rejects a reused nonce, returns the status code and the reason of the failure, or 0.
+// The nonce is only recorded after the signature is verified, so forged requests cannot burn nonces.
+// A request authenticated again by a nested middleware is not taken for a replay of itself.
+func checkNonce(ctx context.Context, c *gin.Context, cfg *AuthenticatorConfigure, auth *authorization) (int, string) {
+	if auth.nonce == "" {
+		if cfg.RequireNonce {
+			return 401, "missing_nonce"
+		}
+		return 0, ""
+	}
+	if cfg.NonceStore == nil {
+		return 0, ""
+	}
+	key := auth.sesID + ":" + auth.nonce
+	if c.GetString(authNonceKey) == key {
+		return 0, ""
+	}
+
+	// The request is acceptable until its timestamp is out of the tolerance
+	ttl := time.Until(time.UnixMilli(auth.ts + cfg.TsTolerance))
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	var fresh bool
+	e := traceCall(ctx, "websvc.auth.UseNonce", func() (err error) {
+		fresh, err = cfg.NonceStore.Use(ctx, key, ttl)
+		return err
+	})
+	if e != nil {
+		if errors.Is(e, ErrNonceStoreFull) {
+			return 503, "nonce_store_full"
+		}
+		logger.Error("NonceStore Error: %+v", e)
+		return 500, "nonce_store_error"
+	}
+	if !fresh {
+		return 409, "nonce_reused"
+	}
+	c.Set(authNonceKey, key)
+	return 0, ""
+}
@@ -0,0 +1,165 @@
+package websvc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNonceSignature(t *testing.T) {
+	// Known-answer signatures of the test request with the nonce "n-1", signed right after the timestamp
+	const (
+		sigV1Nonce = "3fac9b0b90d2dd671a66e190d146c10edbef719aa942c3bcda82bd1edfa54219"
+		sigV2Nonce = "2f44334e2e4a5dd69d9f5ea9bfb8dc22bd98db0654dbbe99891af5557c8004c4"
+	)
+	v2NonceHeader := func(nonce, sig string) string {
+		return "WSV2-HMAC-SHA256 Session=" + testSessionID + ", Timestamp=" + testTimestamp + ", Nonce=" + nonce +
+			", SignedHeaders=content-type;host, Signature=" + sig
+	}
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "v1", header: testSessionID + ":" + sigV1Nonce + ":" + testTimestamp + ":n-1"},
+		{name: "v2", header: v2NonceHeader("n-1", sigV2Nonce)},
+		{name: "v1 nonce not signed", header: testSessionID + ":" + sigV1 + ":" + testTimestamp + ":n-1", want: "invalid_signature"},
+		{name: "v2 nonce not signed", header: v2NonceHeader("n-1", sigV2), want: "invalid_signature"},
+		{name: "v1 other nonce", header: testSessionID + ":" + sigV1Nonce + ":" + testTimestamp + ":n-2", want: "invalid_signature"},
+		{name: "v1 nonce dropped", header: testSessionID + ":" + sigV1Nonce + ":" + testTimestamp, want: "invalid_signature"},
+		{name: "v1 empty nonce", header: testSessionID + ":" + sigV1Nonce + ":" + testTimestamp + ":", want: "malformed_nonce"},
+		{name: "v1 too many parts", header: testSessionID + ":" + sigV1Nonce + ":" + testTimestamp + ":n:1", want: "malformed_authorization"},
+		{name: "v2 colon in nonce", header: v2NonceHeader("n:1", sigV2Nonce), want: "malformed_nonce"},
+		{name: "v2 nonce too long", header: v2NonceHeader(strings.Repeat("n", MaxNonceLength+1), sigV2Nonce), want: "malformed_nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSignedRequest("POST", "/v1/items?"+testRawQuery, "application/json", testBody)
+			if got := verifyRequest(t, c, &AuthenticatorConfigure{}, tt.header, []byte(testBody), true); got != tt.want {
+				t.Errorf("verify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNonceMovedToQuery(t *testing.T) {
+	// A v1 signature with the nonce "n" for ?a=1 covers the same bytes as one without nonce for ?n:a=1
+	mac := hmac.New(sha256.New, []byte(testToken))
+	mac.Write([]byte(testSessionID + ":" + testTimestamp + ":n:a=1"))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   string
+	}{
+		{name: "signed request", target: "/items?a=1", header: testSessionID + ":" + sig + ":" + testTimestamp + ":n"},
+		{name: "nonce moved to the key", target: "/items?n:a=1", header: testSessionID + ":" + sig + ":" + testTimestamp, want: "invalid_signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSignedRequest("GET", tt.target, "", "")
+			if got := verifyRequest(t, c, &AuthenticatorConfigure{}, tt.header, nil, false); got != tt.want {
+				t.Errorf("verify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryNonceStore(2)
+	use := func(nonce string, ttl time.Duration) (bool, error) {
+		t.Helper()
+		return s.Use(ctx, nonce, ttl)
+	}
+
+	if ok, err := use("a", time.Hour); !ok || err != nil {
+		t.Fatalf("first use = %v, %v, want true", ok, err)
+	}
+	if ok, err := use("a", time.Hour); ok || err != nil {
+		t.Fatalf("reuse = %v, %v, want false", ok, err)
+	}
+	if ok, err := use("b", 20*time.Millisecond); !ok || err != nil {
+		t.Fatalf("use b = %v, %v, want true", ok, err)
+	}
+	// Full, the old nonces are not forgotten to make room
+	if _, err := use("c", time.Hour); !errors.Is(err, ErrNonceStoreFull) {
+		t.Fatalf("use when full = %v, want ErrNonceStoreFull", err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	// b has expired, so it is usable again and its room is freed
+	if ok, err := use("b", time.Hour); !ok || err != nil {
+		t.Fatalf("use of expired b = %v, %v, want true", ok, err)
+	}
+	if ok, _ := use("a", time.Hour); ok {
+		t.Fatalf("a expired early")
+	}
+}
+
+// signNow signs a GET request without query as AuthN clients do, with the current time and the nonce
+func signNow(nonce string) string {
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	ts_nonce := ts
+	if nonce != "" {
+		ts_nonce += ":" + nonce
+	}
+	mac := hmac.New(sha256.New, []byte(testToken))
+	mac.Write([]byte(testSessionID + ":" + ts_nonce + ":"))
+	header := testSessionID + ":" + hex.EncodeToString(mac.Sum(nil)) + ":" + ts
+	if nonce != "" {
+		header += ":" + nonce
+	}
+	return header
+}
+
+func TestNonceReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &AuthenticatorConfigure{
+		TsTolerance: 60000,
+		NonceStore:  NewMemoryNonceStore(100),
+		QueryToken: func(ctx context.Context, ses_id string) (interface{}, string, error) {
+			return "session", testToken, nil
+		},
+	}
+	r := gin.New()
+	r.GET("/items", AuthN(cfg, func(c *gin.Context, ses string) (int, interface{}, error) {
+		return 200, ses, nil
+	}, ""))
+	do := func(authorization string) int {
+		req := httptest.NewRequest("GET", "/items", nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	header := signNow("n-1")
+	if code := do(header); code != 200 {
+		t.Fatalf("first request = %d, want 200", code)
+	}
+	if code := do(header); code != 409 {
+		t.Fatalf("replayed request = %d, want 409", code)
+	}
+	if code := do(signNow("n-2")); code != 200 {
+		t.Fatalf("request with a new nonce = %d, want 200", code)
+	}
+	if code := do(signNow("")); code != 200 {
+		t.Fatalf("request without nonce = %d, want 200", code)
+	}
+	cfg.RequireNonce = true
+	if code := do(signNow("")); code != 401 {
+		t.Fatalf("request without nonce when required = %d, want 401", code)
+	}
+}
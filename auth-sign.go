@@ -0,0 +1,232 @@
+package websvc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// The signature modes of AuthenticatorConfigure.SignatureMode
+const (
+	SignatureHMACSHA256 = "HMAC-SHA256"
+	SignatureHMACSHA512 = "HMAC-SHA512"
+	SignatureSHA256     = "SHA256" // The legacy plain hash over the token, it is not a MAC, only for clients not migrated yet
+)
+
+// The prefix of the canonical request signature scheme in the Authorization header, followed by the signature mode,
+// e.g. WSV2-HMAC-SHA256
+const AuthSchemeV2Prefix = "WSV2-"
+
+// The headers a canonical request signature must cover if AuthenticatorConfigure.SignedHeaders is empty
+var DefaultSignedHeaders = []string{"host"}
+
+// authorization is the parsed Authorization header, in either form:
+//
+//	v1: [Bearer ]ses_id:signature:timestamp[:nonce]
+//	v2: WSV2-HMAC-SHA256 Session=ses_id, Timestamp=timestamp, [Nonce=nonce, ]SignedHeaders=content-type;host, Signature=signature
+type authorization struct {
+	version       int
+	mode          string // The signature mode named by the v2 scheme
+	sesID         string
+	signature     string
+	timestamp     string
+	ts            int64
+	nonce         string // [Optional] Signed with the timestamp, see NonceStore
+	signedHeaders []string
+}
+
+// parseAuthorization parses the Authorization header, returns the reason of the failure if it is nil
+func parseAuthorization(auth_str string) (*authorization, string) {
+	if auth_str == "" {
+		return nil, "missing_authorization"
+	}
+
+	auth := &authorization{version: 1}
+	if scheme, params, ok := strings.Cut(auth_str, " "); ok && len(scheme) > len(AuthSchemeV2Prefix) &&
+		strings.EqualFold(scheme[:len(AuthSchemeV2Prefix)], AuthSchemeV2Prefix) {
+		auth.version = 2
+		auth.mode = strings.ToUpper(scheme[len(AuthSchemeV2Prefix):])
+		for _, param := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch k {
+			case "Session":
+				auth.sesID = v
+			case "Timestamp":
+				auth.timestamp = v
+			case "Nonce":
+				auth.nonce = v
+			case "SignedHeaders":
+				for _, h := range strings.Split(v, ";") {
+					if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+						auth.signedHeaders = append(auth.signedHeaders, h)
+					}
+				}
+			case "Signature":
+				auth.signature = v
+			}
+		}
+		if auth.sesID == "" || auth.signature == "" || auth.timestamp == "" {
+			return nil, "malformed_authorization"
+		}
+	} else {
+		auth_parts := strings.Split(strings.ReplaceAll(auth_str, "Bearer ", ""), ":")
+		if len(auth_parts) != 3 && len(auth_parts) != 4 {
+			return nil, "malformed_authorization"
+		}
+		auth.sesID = auth_parts[0]
+		auth.signature = auth_parts[1]
+		auth.timestamp = auth_parts[2]
+		if len(auth_parts) == 4 {
+			if auth_parts[3] == "" {
+				return nil, "malformed_nonce"
+			}
+			auth.nonce = auth_parts[3]
+		}
+	}
+	// A ":" in the nonce would make the signed message ambiguous
+	if len(auth.nonce) > MaxNonceLength || strings.ContainsAny(auth.nonce, ": ") {
+		return nil, "malformed_nonce"
+	}
+
+	ts, e := strconv.ParseInt(auth.timestamp, 10, 64)
+	if e != nil {
+		return nil, "malformed_timestamp"
+	}
+	auth.ts = ts
+	return auth, ""
+}
+
+// verify checks the signature of the request, returns the reason of the failure or an empty string.
+// withBody is false for the requests authenticated without body (doAuthN), whose v1 signatures have no body hash.
+func (auth *authorization) verify(c *gin.Context, cfg *AuthenticatorConfigure, token string, queries url.Values, body []byte, withBody bool) string {
+	mode := cfg.SignatureMode
+	if mode == "" {
+		mode = SignatureHMACSHA256
+	}
+	if signatureHash(mode) == nil {
+		logger.Error("Auth: unsupported signature mode %s", mode)
+		return "unsupported_signature_mode"
+	}
+	// The signature is decoded, so the comparison is constant-time on the bytes
+	signature, e := hex.DecodeString(auth.signature)
+	if e != nil {
+		return "invalid_signature"
+	}
+
+	if auth.version == 1 {
+		if cfg.RequireSignatureV2 {
+			return "legacy_signature"
+		}
+		body_hash := ""
+		if len(body) > 0 {
+			body_hash_bytes := sha256.Sum256(body)
+			body_hash = hex.EncodeToString(body_hash_bytes[:])
+		}
+		matched := false
+		for _, query := range signedQueries(queries) {
+			msg := query
+			if withBody {
+				msg += ":" + body_hash
+			}
+			// Every form is checked, so the time taken does not tell which one matched
+			if hmac.Equal(signature, auth.sign(mode, token, msg)) {
+				matched = true
+			}
+		}
+		if !matched {
+			return "invalid_signature"
+		}
+		return ""
+	}
+
+	if auth.mode != mode {
+		return "signature_mode_mismatch"
+	}
+	required := cfg.SignedHeaders
+	if len(required) == 0 {
+		required = DefaultSignedHeaders
+	}
+	for _, h := range required {
+		if !slices.Contains(auth.signedHeaders, strings.ToLower(h)) {
+			return "unsigned_header"
+		}
+	}
+	// A body is always covered by the content type, so it cannot be decoded differently
+	if len(body) > 0 && !slices.Contains(auth.signedHeaders, "content-type") {
+		return "unsigned_header"
+	}
+
+	canonical := canonicalRequest(c, auth.signedHeaders, canonicalQuery(queries), body)
+	if !hmac.Equal(signature, auth.sign(mode, token, canonical)) {
+		return "invalid_signature"
+	}
+	return ""
+}
+
+// signatureHash returns the hash of the signature mode, nil if it is unknown
+func signatureHash(mode string) func() hash.Hash {
+	switch mode {
+	case SignatureHMACSHA256, SignatureSHA256:
+		return sha256.New
+	case SignatureHMACSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// sign calculates the signature of the message in the mode:
+//
+//	HMAC modes:      hmac(token, ses_id + ":" + timestamp + ":" + msg)
+//	SignatureSHA256: sha256(ses_id + ":" + token + ":" + timestamp + ":" + msg)
+//
+// where timestamp is followed by ":" + nonce if the request has a nonce.
+func (auth *authorization) sign(mode string, token string, msg string) []byte {
+	ts_str := auth.timestamp
+	if auth.nonce != "" {
+		ts_str += ":" + auth.nonce
+	}
+	if mode == SignatureSHA256 {
+		sig_hash := sha256.Sum256([]byte(auth.sesID + ":" + token + ":" + ts_str + ":" + msg))
+		return sig_hash[:]
+	}
+	mac := hmac.New(signatureHash(mode), []byte(token))
+	mac.Write([]byte(auth.sesID + ":" + ts_str + ":" + msg))
+	return mac.Sum(nil)
+}
+
+// canonicalRequest builds the request description covered by v2 signatures, the lines are:
+//
+//	method
+//	escaped path
+//	canonical query, see canonicalQuery
+//	name:value of each signed header, in the order of SignedHeaders, names in lower case, values trimmed
+//	signed header names joined by ";"
+//	hex sha256 of the body, of the empty string if there is no body
+func canonicalRequest(c *gin.Context, signedHeaders []string, query string, body []byte) string {
+	var sb strings.Builder
+	sb.WriteString(c.Request.Method + "\n")
+	sb.WriteString(c.Request.URL.EscapedPath() + "\n")
+	sb.WriteString(query + "\n")
+	for _, h := range signedHeaders {
+		var v string
+		if h == "host" {
+			v = c.Request.Host
+		} else {
+			v = strings.Join(c.Request.Header.Values(h), ",")
+		}
+		sb.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	sb.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	body_hash := sha256.Sum256(body)
+	sb.WriteString(hex.EncodeToString(body_hash[:]))
+	return sb.String()
+}
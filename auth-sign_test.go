@@ -0,0 +1,229 @@
+package websvc
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The request all known-answer signatures below are made for, with the token "secret"
+const (
+	testSessionID = "sid"
+	testToken     = "secret"
+	testTimestamp = "1700000000000"
+	testBody      = `{"n":1}`
+	testBodyHash  = "2bfd14f43d17fc7cea24e0917a8879b4b2f880b8baeec1b9d90fbaad655e71bd"
+	testRawQuery  = "b=2&a=x%26y&a=1"
+)
+
+func newSignedRequest(method, target, contentType, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+	c.Request.Host = "example.com"
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	return c
+}
+
+// verifyRequest verifies the Authorization header as doAuth (withBody) or doAuthN would
+func verifyRequest(t *testing.T, c *gin.Context, cfg *AuthenticatorConfigure, authorization string, body []byte, withBody bool) string {
+	t.Helper()
+	auth, reason := parseAuthorization(authorization)
+	if auth == nil {
+		return reason
+	}
+	values, err := url.ParseQuery(c.Request.URL.RawQuery)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	return auth.verify(c, cfg, testToken, values, body, withBody)
+}
+
+func TestCanonicalRequest(t *testing.T) {
+	c := newSignedRequest("POST", "/v1/items?"+testRawQuery, "application/json", testBody)
+	values, _ := url.ParseQuery(c.Request.URL.RawQuery)
+	got := canonicalRequest(c, []string{"content-type", "host"}, canonicalQuery(values), []byte(testBody))
+	want := "POST\n" +
+		"/v1/items\n" +
+		"a=x%26y&a=1&b=2\n" +
+		"content-type:application/json\n" +
+		"host:example.com\n" +
+		"content-type;host\n" +
+		testBodyHash
+	if got != want {
+		t.Errorf("canonicalRequest =\n%s\nwant\n%s", got, want)
+	}
+
+	// The path is kept escaped, and a missing body hashes as the empty string
+	c = newSignedRequest("GET", "/a%2Fb/c%20d", "", "")
+	got = canonicalRequest(c, []string{"host"}, "", nil)
+	want = "GET\n/a%2Fb/c%20d\n\nhost:example.com\nhost\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got != want {
+		t.Errorf("canonicalRequest =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseAuthorization(t *testing.T) {
+	tests := []struct {
+		header     string
+		wantReason string
+		want       authorization
+	}{
+		{"", "missing_authorization", authorization{}},
+		{"sid:sig", "malformed_authorization", authorization{}},
+		{"sid:sig:abc", "malformed_timestamp", authorization{}},
+		{"sid:sig:1700000000000",
+			"", authorization{version: 1, sesID: "sid", signature: "sig", timestamp: "1700000000000", ts: 1700000000000}},
+		{"Bearer sid:sig:1700000000000",
+			"", authorization{version: 1, sesID: "sid", signature: "sig", timestamp: "1700000000000", ts: 1700000000000}},
+		{"WSV2-HMAC-SHA256 Session=sid, Timestamp=1700000000000, SignedHeaders=Host;Content-Type, Signature=sig",
+			"", authorization{version: 2, mode: "HMAC-SHA256", sesID: "sid", signature: "sig", timestamp: "1700000000000", ts: 1700000000000,
+				signedHeaders: []string{"host", "content-type"}}},
+		{"WSV2-HMAC-SHA256 Session=sid, Timestamp=1700000000000", "malformed_authorization", authorization{}},
+	}
+	for _, tt := range tests {
+		got, reason := parseAuthorization(tt.header)
+		if reason != tt.wantReason {
+			t.Errorf("parseAuthorization(%q) reason = %q, want %q", tt.header, reason, tt.wantReason)
+			continue
+		}
+		if got == nil {
+			continue
+		}
+		if got.version != tt.want.version || got.mode != tt.want.mode || got.sesID != tt.want.sesID ||
+			got.signature != tt.want.signature || got.ts != tt.want.ts ||
+			len(got.signedHeaders) != len(tt.want.signedHeaders) {
+			t.Errorf("parseAuthorization(%q) = %+v, want %+v", tt.header, *got, tt.want)
+			continue
+		}
+		for i := range got.signedHeaders {
+			if got.signedHeaders[i] != tt.want.signedHeaders[i] {
+				t.Errorf("parseAuthorization(%q) signedHeaders = %q, want %q", tt.header, got.signedHeaders, tt.want.signedHeaders)
+			}
+		}
+	}
+}
+
+// Known-answer signatures of the test request, calculated independently of the package
+const (
+	sigV1 = "bfb7d7d15a7a244c59910f2d8dd2b0a567129c18a9e84eada429f66c4ce57d30"
+	sigV2 = "e16fb238f37bb9ee42b28f56c0dc7aac24d3ddd194a41767b08fa6a36981098a"
+
+	// Signatures of GET requests without body, as verified by doAuthN
+	sigV1SpaceCanonical = "88c1ebfba5b342ab6bd3c90cbd24aaf2d4387d2526c540608a3ed4e143bb41ad" // q=hello%20world
+	sigV1SpaceLegacy    = "f1802f15e25c9f1be343a1cde8a27d94cf85aa1d95a8f32d4abe73cbf7ef12bb" // q=hello world
+	sigV1EscapedAmp     = "ec74de5410502ab0b35247bd7963560eb79791709edcffb0e2a40fa4cdc21940" // a=x%26b%3Dy
+	sigV1EscapedAmpOld  = "8fe05beb2be7c9b66222a449274dd883e98def3d24f1cec460b3e83b73074dc0" // a=x&b=y
+	sigV1Repeated       = "92c186447d5711f2d921801c2395ed2b03c21a4acfb51d7eadd1ebd7f41f7675" // a=1&a=2
+	sigV1RepeatedOld    = "3efbd1610195daf076d43ec456ff84101285e6e16535efb0e3e3670987fd9c6e" // a=2
+)
+
+func v2Header(scheme, signedHeaders, signature string) string {
+	return scheme + " Session=" + testSessionID + ", Timestamp=" + testTimestamp +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+}
+
+func TestVerifySignature(t *testing.T) {
+	v1Header := func(sig string) string { return testSessionID + ":" + sig + ":" + testTimestamp }
+	tests := []struct {
+		name     string
+		cfg      AuthenticatorConfigure
+		method   string
+		target   string
+		header   string
+		withBody bool
+		want     string
+	}{
+		{name: "v1", header: v1Header(sigV1), withBody: true},
+		{name: "v1 bearer", header: "Bearer " + v1Header(sigV1), withBody: true},
+		{name: "v1 wrong token", header: v1Header(sigV2), withBody: true, want: "invalid_signature"},
+		{name: "v1 not hex", header: v1Header("zz"), withBody: true, want: "invalid_signature"},
+		{name: "v1 rejected", cfg: AuthenticatorConfigure{RequireSignatureV2: true},
+			header: v1Header(sigV1), withBody: true, want: "legacy_signature"},
+		{name: "v1 legacy query", method: "GET", target: "/v1/items?q=hello%20world", header: v1Header(sigV1SpaceLegacy)},
+		{name: "v1 canonical query", method: "GET", target: "/v1/items?q=hello%20world", header: v1Header(sigV1SpaceCanonical)},
+		{name: "v1 escaped separators", method: "GET", target: "/v1/items?a=x%26b%3Dy", header: v1Header(sigV1EscapedAmp)},
+		{name: "v1 escaped separators as legacy", method: "GET", target: "/v1/items?a=x%26b%3Dy",
+			header: v1Header(sigV1EscapedAmpOld), want: "invalid_signature"},
+		{name: "v1 repeated key", method: "GET", target: "/v1/items?a=1&a=2", header: v1Header(sigV1Repeated)},
+		{name: "v1 repeated key as legacy", method: "GET", target: "/v1/items?a=1&a=2",
+			header: v1Header(sigV1RepeatedOld), want: "invalid_signature"},
+
+		{name: "v2", header: v2Header("WSV2-HMAC-SHA256", "content-type;host", sigV2), withBody: true},
+		{name: "v2 other method", method: "PUT", header: v2Header("WSV2-HMAC-SHA256", "content-type;host", sigV2),
+			withBody: true, want: "invalid_signature"},
+		{name: "v2 other path", target: "/v1/other?" + testRawQuery, header: v2Header("WSV2-HMAC-SHA256", "content-type;host", sigV2),
+			withBody: true, want: "invalid_signature"},
+		{name: "v2 other query", target: "/v1/items?a=1&b=2", header: v2Header("WSV2-HMAC-SHA256", "content-type;host", sigV2),
+			withBody: true, want: "invalid_signature"},
+		{name: "v2 host unsigned", header: v2Header("WSV2-HMAC-SHA256", "content-type", sigV2),
+			withBody: true, want: "unsigned_header"},
+		{name: "v2 content type unsigned", header: v2Header("WSV2-HMAC-SHA256", "host", sigV2),
+			withBody: true, want: "unsigned_header"},
+		{name: "v2 required header unsigned", cfg: AuthenticatorConfigure{SignedHeaders: []string{"Host", "X-Tenant"}},
+			header: v2Header("WSV2-HMAC-SHA256", "content-type;host", sigV2), withBody: true, want: "unsigned_header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, target := tt.method, tt.target
+			if method == "" {
+				method = "POST"
+			}
+			if target == "" {
+				target = "/v1/items?" + testRawQuery
+			}
+			var body []byte
+			c := newSignedRequest(method, target, "", "")
+			if method != "GET" {
+				body = []byte(testBody)
+				c = newSignedRequest(method, target, "application/json", testBody)
+			}
+			if got := verifyRequest(t, c, &tt.cfg, tt.header, body, tt.withBody); got != tt.want {
+				t.Errorf("verify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignatureModes(t *testing.T) {
+	const (
+		sigV1SHA512 = "1b8996b0c96e881ba783663f7d2e4d7ccde6e6be118553c699bf84e76e1372d17753cb3601f85bbfe08846f426c3edb176f34617f49c87b69cb81cab56665551"
+		sigV2SHA512 = "18ebd9620634761ae97508641cbadbd23b707edef9ac0cb23e613dafa0a8d30082ad589bba89a355270ac824014987aa3a2c50a2ae39af58f9c6b8d38b198b42"
+		sigV1Plain  = "20925d735e2abef0868b7e921ddebfc125f202db228123353ce74053dd822118" // sha256(ses_id:token:timestamp:query:body_hash)
+	)
+	v1Header := func(sig string) string { return testSessionID + ":" + sig + ":" + testTimestamp }
+	tests := []struct {
+		name   string
+		mode   string
+		header string
+		want   string
+	}{
+		{name: "default is HMAC-SHA256", header: v1Header(sigV1)},
+		{name: "upper case hex", header: v1Header(strings.ToUpper(sigV1))},
+		{name: "default rejects plain hash", header: v1Header(sigV1Plain), want: "invalid_signature"},
+		{name: "default rejects HMAC-SHA512", header: v1Header(sigV1SHA512), want: "invalid_signature"},
+		{name: "HMAC-SHA256", mode: SignatureHMACSHA256, header: v1Header(sigV1)},
+		{name: "HMAC-SHA512 v1", mode: SignatureHMACSHA512, header: v1Header(sigV1SHA512)},
+		{name: "HMAC-SHA512 v2", mode: SignatureHMACSHA512, header: v2Header("WSV2-HMAC-SHA512", "content-type;host", sigV2SHA512)},
+		{name: "HMAC-SHA512 rejects HMAC-SHA256", mode: SignatureHMACSHA512, header: v1Header(sigV1), want: "invalid_signature"},
+		{name: "v2 scheme of another mode", mode: SignatureHMACSHA512,
+			header: v2Header("WSV2-HMAC-SHA256", "content-type;host", sigV2), want: "signature_mode_mismatch"},
+		{name: "legacy plain hash", mode: SignatureSHA256, header: v1Header(sigV1Plain)},
+		{name: "legacy rejects HMAC", mode: SignatureSHA256, header: v1Header(sigV1), want: "invalid_signature"},
+		{name: "unknown mode", mode: "MD5", header: v1Header(sigV1), want: "unsupported_signature_mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSignedRequest("POST", "/v1/items?"+testRawQuery, "application/json", testBody)
+			cfg := &AuthenticatorConfigure{SignatureMode: tt.mode}
+			if got := verifyRequest(t, c, cfg, tt.header, []byte(testBody), true); got != tt.want {
+				t.Errorf("verify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
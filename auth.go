@@ -2,30 +2,38 @@ package websvc
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"io"
-	"strconv"
-	"strings"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/acsl-go/logger"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-type Response struct {
-	ContentType string
-	Body        []byte
-}
-
 type AuthenticatorConfigure struct {
 	QueryToken     func(context.Context, string) (interface{}, string, error)
 	CheckPrivilege func(context.Context, string, interface{}, string) error
 	RefreshToken   func(context.Context, string, interface{}) error
 	SuperPrivilege string
 	TsTolerance    int64
+
+	// [Optional] The headers v2 signatures must cover, DefaultSignedHeaders if empty
+	SignedHeaders []string
+
+	// [Optional] Reject the legacy v1 signatures, once all clients have migrated to v2
+	RequireSignatureV2 bool
+
+	// [Optional] The signature algorithm, SignatureHMACSHA256 if empty.
+	// Set SignatureSHA256 explicitly to accept the plain hash signatures of old clients.
+	SignatureMode string
+
+	// [Optional] Rejects a nonce used again within TsTolerance, so signed requests cannot be replayed.
+	// Set RequireNonce to also reject requests without nonce.
+	NonceStore   NonceStore
+	RequireNonce bool
 }
 
 var (
@@ -33,85 +41,111 @@ var (
 )
 
 // Do authentication
-// The signature will be calculated with:
+// The v1 signature will be calculated with:
 //
-//	sha256(ses_id + ":" + token + ":" + timestamp + ":" + query + ":" + body_hash)
-func doAuth(c *gin.Context, cfg *AuthenticatorConfigure, privilege string) (interface{}, map[string]string, []byte, int) {
-	auth_str := c.GetHeader("Authorization")
-	if auth_str == "" {
-		return nil, nil, nil, 401
-	}
-
-	auth_parts := strings.Split(strings.ReplaceAll(auth_str, "Bearer ", ""), ":")
-	if len(auth_parts) != 3 {
-		return nil, nil, nil, 401
-	}
-
-	ses_id := auth_parts[0]
-	signature := auth_parts[1]
-	timestamp, e := strconv.ParseInt(auth_parts[2], 10, 64)
-	if e != nil {
-		return nil, nil, nil, 401
+//	hmac_sha256(token, ses_id + ":" + timestamp + ":" + query + ":" + body_hash)
+//
+// where query is the canonical query, see canonicalQuery.
+// The legacy unescaped query is still accepted as long as it is unambiguous.
+//
+// The v2 signature, marked by the WSV2-HMAC-SHA256 scheme, also covers the method, path and headers:
+//
+//	hmac_sha256(token, ses_id + ":" + timestamp + ":" + canonical_request)
+//
+// see canonicalRequest. The algorithm is selected by cfg.SignatureMode, see sign.
+// A nonce, if any, is signed after the timestamp and checked with cfg.NonceStore, a reused one is rejected with 409.
+func doAuth(c *gin.Context, cfg *AuthenticatorConfigure, privilege string) (interface{}, url.Values, []byte, int) {
+	ctx, span := StartSpan(c, "websvc.auth")
+	defer span.End()
+
+	auth, reason := parseAuthorization(c.GetHeader("Authorization"))
+	if auth == nil {
+		return nil, nil, nil, authResult(span, 401, reason)
 	}
+	span.SetAttributes(attribute.Int("auth.version", auth.version))
 
-	ts_error := time.Now().UnixMilli() - timestamp
+	ses_id := auth.sesID
+	ts_error := time.Now().UnixMilli() - auth.ts
 	if ts_error > cfg.TsTolerance || ts_error < -cfg.TsTolerance {
-		return nil, nil, nil, 400
+		return nil, nil, nil, authResult(span, 400, "timestamp_out_of_range")
 	}
 
-	ses, token, e := cfg.QueryToken(c, ses_id)
+	var ses interface{}
+	var token string
+	e := traceCall(ctx, "websvc.auth.QueryToken", func() (err error) {
+		ses, token, err = cfg.QueryToken(c, ses_id)
+		return err
+	})
 	if e != nil {
 		logger.Error("QueryToken Error: %+v", e)
-		return nil, nil, nil, 500
+		return nil, nil, nil, authResult(span, 500, "query_token_error")
 	}
 
 	if ses == nil || token == "" {
-		return nil, nil, nil, 401
+		return nil, nil, nil, authResult(span, 401, "unknown_session")
 	}
 
-	queries, query, e := parseQuery(c)
-	if e != nil {
-		return nil, nil, nil, 400
-	}
+	queries := parseQuery(c)
 
 	var body_bytes []byte = nil
-	var body_hash string = ""
 
-	if c.Request.ContentLength > 0 {
+	// A chunked body has no ContentLength, it must be signed all the same
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
 		body_bytes, e = io.ReadAll(c.Request.Body)
 		if e != nil {
+			if isBodyTooLarge(e) {
+				return nil, nil, nil, authResult(span, 413, "body_too_large")
+			}
 			logger.Error("Read body failed: %+v", e)
-			return nil, nil, nil, 500
+			return nil, nil, nil, authResult(span, 500, "read_body_error")
 		}
-		body_hash_bytes := sha256.Sum256(body_bytes)
-		body_hash = hex.EncodeToString(body_hash_bytes[:])
 	}
 
-	sig_str := auth_parts[0] + ":" + token + ":" + auth_parts[2] + ":" + query + ":" + body_hash
-	sig_hash := sha256.Sum256([]byte(sig_str))
-	if signature != hex.EncodeToString(sig_hash[:]) {
-		return nil, nil, nil, 401
+	if reason := auth.verify(c, cfg, token, queries, body_bytes, true); reason != "" {
+		return nil, nil, nil, authResult(span, 401, reason)
 	}
 
-	if cfg.CheckPrivilege != nil && privilege != "" {
-		if e := cfg.CheckPrivilege(c, ses_id, ses, privilege); e != nil {
-			if errors.Is(e, ErrNoPrivilege) {
-				return nil, nil, nil, 403
-			} else {
-				logger.Fatal("privilege checking failed: %+v", e)
-				return nil, nil, nil, 500
-			}
-		}
+	if code, reason := checkNonce(ctx, c, cfg, auth); code != 0 {
+		return nil, nil, nil, authResult(span, code, reason)
+	}
+
+	if code, reason := checkPrivilege(ctx, c, cfg, ses_id, ses, privilege); code != 0 {
+		return nil, nil, nil, authResult(span, code, reason)
 	}
 
 	if cfg.RefreshToken != nil {
-		if e := cfg.RefreshToken(c, ses_id, ses); e != nil {
+		e := traceCall(ctx, "websvc.auth.RefreshToken", func() error {
+			return cfg.RefreshToken(c, ses_id, ses)
+		})
+		if e != nil {
 			logger.Fatal("token refresh failed: %+v", e)
-			return nil, nil, nil, 500
+			return nil, nil, nil, authResult(span, 500, "refresh_token_error")
 		}
 	}
 
-	return ses, queries, body_bytes, 0
+	c.Set(sessionIDKey, ses_id)
+	c.Set(sessionKey, ses)
+	c.Set(authBodyKey, true)
+	return ses, queries, body_bytes, authResult(span, 0, "authenticated")
+}
+
+// checkPrivilege checks the privilege of the session with cfg.CheckPrivilege,
+// returns the status code and the reason of the failure, or 0
+func checkPrivilege(ctx context.Context, c *gin.Context, cfg *AuthenticatorConfigure, ses_id string, ses interface{}, privilege string) (int, string) {
+	if cfg.CheckPrivilege == nil || privilege == "" {
+		return 0, ""
+	}
+	e := traceCall(ctx, "websvc.auth.CheckPrivilege", func() error {
+		return cfg.CheckPrivilege(c, ses_id, ses, privilege)
+	})
+	if e != nil {
+		if errors.Is(e, ErrNoPrivilege) {
+			return 403, "no_privilege"
+		}
+		logger.Fatal("privilege checking failed: %+v", e)
+		return 500, "check_privilege_error"
+	}
+	return 0, ""
 }
 
 func Auth[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES) (int, interface{}, error), privilege string) gin.HandlerFunc {
@@ -131,8 +165,7 @@ func Auth[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Conte
 
 		code, rsp, e := handler(c, ses)
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -156,16 +189,14 @@ func AuthD[TSES interface{}, TDATA interface{}](cfg *AuthenticatorConfigure, han
 		}
 
 		var data TDATA
-		if e := json.Unmarshal(data_bytes, &data); e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(400)
+		if e := decodeBody(c, data_bytes, &data); e != nil {
+			abortWithError(c, e)
 			return
 		}
 
 		code, rsp, e := handler(c, ses, data)
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -188,10 +219,9 @@ func AuthQ[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Cont
 			return
 		}
 
-		code, rsp, e := handler(c, ses, queries)
+		code, rsp, e := handler(c, ses, flattenQuery(queries))
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -215,19 +245,75 @@ func AuthQD[TSES interface{}, TDATA interface{}](cfg *AuthenticatorConfigure, ha
 		}
 
 		var data TDATA
-		if e := json.Unmarshal(data_bytes, &data); e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(400)
+		if e := decodeBody(c, data_bytes, &data); e != nil {
+			abortWithError(c, e)
 			return
 		}
 
-		code, rsp, e := handler(c, ses, queries, data)
+		code, rsp, e := handler(c, ses, flattenQuery(queries), data)
 		if e != nil {
-			logger.Error("Error: %+v", e)
+			abortWithError(c, e)
+			return
+		}
+
+		processResp(c, code, rsp)
+	}
+}
+
+// AuthQM is AuthQ with all the values of repeated query keys
+func AuthQM[TSES interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES, url.Values) (int, interface{}, error), privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ses_obj, queries, _, code := doAuth(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+
+		ses, ok := ses_obj.(TSES)
+		if !ok {
+			logger.Fatal("Auth data type miss-match in %s", c.Request.URL.Path)
 			c.AbortWithStatus(500)
 			return
 		}
 
+		code, rsp, e := handler(c, ses, queries)
+		if e != nil {
+			abortWithError(c, e)
+			return
+		}
+
+		processResp(c, code, rsp)
+	}
+}
+
+// AuthQDM is AuthQD with all the values of repeated query keys
+func AuthQDM[TSES interface{}, TDATA interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES, url.Values, TDATA) (int, interface{}, error), privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ses_obj, queries, data_bytes, code := doAuth(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+
+		ses, ok := ses_obj.(TSES)
+		if !ok {
+			logger.Fatal("Auth data type miss-match in %s", c.Request.URL.Path)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		var data TDATA
+		if e := decodeBody(c, data_bytes, &data); e != nil {
+			abortWithError(c, e)
+			return
+		}
+
+		code, rsp, e := handler(c, ses, queries, data)
+		if e != nil {
+			abortWithError(c, e)
+			return
+		}
+
 		processResp(c, code, rsp)
 	}
 }
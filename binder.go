@@ -0,0 +1,282 @@
+package websvc
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldError describes a field of the request which failed to bind or validate
+type FieldError struct {
+	Field   string `json:"field"`
+	Source  string `json:"source"`         // "path", "query", "header", "cookie" or "body"
+	Rule    string `json:"rule,omitempty"` // The failed validation rule, e.g. "required"
+	Message string `json:"message"`
+}
+
+// FieldErrors aggregates the field errors of a request
+type FieldErrors []FieldError
+
+func (fe FieldErrors) Error() string {
+	msgs := make([]string, 0, len(fe))
+	for _, e := range fe {
+		msgs = append(msgs, e.Source+" "+e.Field+": "+e.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// The binding sources, in the order they are applied
+var bindSources = []string{"path", "query", "header", "cookie"}
+
+// Bind fills the struct pointed by dst from the request.
+// Fields are bound with the tags:
+//
+//	path:"name"     the path parameter
+//	query:"name"    the query parameter, slices take all the values
+//	header:"Name"   the header, slices take all the values
+//	cookie:"name"   the cookie
+//	body:""         the whole body decoded by its Content-Type, if no field has this tag the body is decoded into the struct itself
+//	default:"v"     the value used if the parameter is missing, comma separated for slices
+//	time_format:"2006-01-02"  the layout of time.Time fields, RFC3339 by default
+//
+// Strings, bools, ints, uints, floats, time.Time, time.Duration, encoding.TextUnmarshaler,
+// pointers and slices of them are supported. The bound struct is then checked with Validate.
+// All the failed fields are reported together by an HTTPError with status 400 and the FieldErrors as details.
+func Bind(c *gin.Context, dst interface{}) error {
+	var body []byte
+	if c.Request.ContentLength != 0 {
+		var e error
+		if body, e = readBody(c); e != nil {
+			return e
+		}
+	}
+	return bindRequest(c, dst, body)
+}
+
+// bindRequest binds the request with the body already read
+func bindRequest(c *gin.Context, dst interface{}, body []byte) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		// Bind anything else from the body only, as HandlerD does
+		if len(body) > 0 {
+			return decodeBody(c, body, dst)
+		}
+		return Validate(dst)
+	}
+
+	b := &binder{c: c, query: c.Request.URL.Query()}
+	if len(body) > 0 {
+		target := dst
+		if f, ok := findBodyField(v.Elem()); ok {
+			target = f.Addr().Interface()
+		}
+		cd, e := requestCodec(c)
+		if e != nil {
+			return e
+		}
+		if e := cd.Unmarshal(body, target); e != nil {
+			b.errs = append(b.errs, bodyFieldError(e))
+		}
+	}
+	b.bindStruct(v.Elem())
+
+	if len(b.errs) > 0 {
+		return ErrInvalidRequest.WithDetails(b.errs).Wrap(b.errs)
+	}
+	return Validate(dst)
+}
+
+func bodyFieldError(e error) FieldError {
+	fe := FieldError{Source: "body", Message: e.Error()}
+	var te *json.UnmarshalTypeError
+	if errors.As(e, &te) {
+		fe.Field = te.Field
+		fe.Message = "expected " + te.Type.String() + " but got " + te.Value
+	}
+	return fe
+}
+
+func findBodyField(v reflect.Value) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("body"); ok && t.Field(i).IsExported() {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+type binder struct {
+	c     *gin.Context
+	query url.Values
+	errs  FieldErrors
+}
+
+func (b *binder) bindStruct(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			b.bindStruct(fv)
+			continue
+		}
+		for _, source := range bindSources {
+			name, ok := sf.Tag.Lookup(source)
+			if !ok || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			values, found := b.lookup(source, name)
+			if !found {
+				def, ok := sf.Tag.Lookup("default")
+				if !ok {
+					continue
+				}
+				values = []string{def}
+				if isSliceField(sf.Type) {
+					values = strings.Split(def, ",")
+				}
+			}
+			if e := setField(fv, values, sf.Tag.Get("time_format")); e != nil {
+				b.errs = append(b.errs, FieldError{Field: name, Source: source, Message: e.Error()})
+			}
+			break
+		}
+	}
+}
+
+func (b *binder) lookup(source, name string) ([]string, bool) {
+	switch source {
+	case "path":
+		if v, ok := b.c.Params.Get(name); ok {
+			return []string{v}, true
+		}
+	case "query":
+		if v, ok := b.query[name]; ok {
+			return v, true
+		}
+	case "header":
+		if v := b.c.Request.Header.Values(name); len(v) > 0 {
+			return v, true
+		}
+	case "cookie":
+		if ck, e := b.c.Request.Cookie(name); e == nil {
+			return []string{ck.Value}, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	textType     = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func isSliceField(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func setField(v reflect.Value, values []string, layout string) error {
+	if v.Kind() == reflect.Pointer {
+		nv := reflect.New(v.Type().Elem())
+		if e := setField(nv.Elem(), values, layout); e != nil {
+			return e
+		}
+		v.Set(nv)
+		return nil
+	}
+	if isSliceField(v.Type()) && !reflect.PointerTo(v.Type()).Implements(textType) {
+		s := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if e := setField(s.Index(i), []string{value}, layout); e != nil {
+				return e
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return setScalar(v, values[0], layout)
+}
+
+func setScalar(v reflect.Value, value, layout string) error {
+	switch v.Type() {
+	case timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, e := time.Parse(layout, value)
+		if e != nil {
+			return fmt.Errorf("invalid time %q, expected the layout %s", value, layout)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, e := time.ParseDuration(value)
+		if e != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if e := u.UnmarshalText([]byte(value)); e != nil {
+			return fmt.Errorf("invalid value %q: %v", value, e)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(value)
+		if e != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, e := strconv.ParseInt(value, 10, v.Type().Bits())
+		if e != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, e := strconv.ParseUint(value, 10, v.Type().Bits())
+		if e != nil {
+			return fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, e := strconv.ParseFloat(value, v.Type().Bits())
+		if e != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice: // []byte
+		v.SetBytes([]byte(value))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
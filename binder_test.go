@@ -0,0 +1,146 @@
+package websvc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type binderBody struct {
+	Name string `json:"name"`
+}
+
+type binderRequest struct {
+	ID       int64         `path:"id"`
+	Tags     []string      `query:"tag"`
+	Limit    int           `query:"limit" default:"10"`
+	Kinds    []int         `query:"kind" default:"1,2"`
+	Since    time.Time     `query:"since" time_format:"2006-01-02"`
+	Until    *time.Time    `query:"until"`
+	Timeout  time.Duration `query:"timeout"`
+	Trace    string        `header:"X-Trace-Id"`
+	Language []string      `header:"Accept-Language"`
+	Session  string        `cookie:"session"`
+	Body     binderBody    `body:""`
+}
+
+// bindTest binds the request to the route /items/:id
+func bindTest(t *testing.T, req *http.Request, dst interface{}) error {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var err error
+	r := gin.New()
+	r.POST("/items/:id", func(c *gin.Context) {
+		err = Bind(c, dst)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	return err
+}
+
+func TestBindSources(t *testing.T) {
+	req := httptest.NewRequest("POST", "/items/42?tag=a&tag=b&limit=5&kind=3&since=2024-01-02&until=2024-01-03T04:05:06Z&timeout=1m30s",
+		strings.NewReader(`{"name":"n"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Trace-Id", "t-1")
+	req.Header.Add("Accept-Language", "en")
+	req.Header.Add("Accept-Language", "fr")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s-1"})
+
+	var got binderRequest
+	if err := bindTest(t, req, &got); err != nil {
+		t.Fatal(err)
+	}
+	until := time.Date(2024, 1, 3, 4, 5, 6, 0, time.UTC)
+	want := binderRequest{
+		ID:       42,
+		Tags:     []string{"a", "b"},
+		Limit:    5,
+		Kinds:    []int{3},
+		Since:    time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Until:    &until,
+		Timeout:  90 * time.Second,
+		Trace:    "t-1",
+		Language: []string{"en", "fr"},
+		Session:  "s-1",
+		Body:     binderBody{Name: "n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bound %+v, want %+v", got, want)
+	}
+}
+
+func TestBindDefaults(t *testing.T) {
+	var got binderRequest
+	if err := bindTest(t, httptest.NewRequest("POST", "/items/1", nil), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Limit != 10 || !slices.Equal(got.Kinds, []int{1, 2}) {
+		t.Errorf("defaults = %d, %v, want 10, [1 2]", got.Limit, got.Kinds)
+	}
+	if got.Tags != nil || got.Until != nil {
+		t.Errorf("fields without value or default are set: %v, %v", got.Tags, got.Until)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	req := httptest.NewRequest("POST", "/items/x?limit=ten&kind=1&kind=two&since=2024-01-02T00:00:00Z&timeout=5",
+		strings.NewReader(`{"name":1}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var got binderRequest
+	err := bindTest(t, req, &got)
+	var he *HTTPError
+	if !errors.As(err, &he) || he.Status != 400 {
+		t.Fatalf("Bind = %v, want 400", err)
+	}
+	var fe FieldErrors
+	if !errors.As(err, &fe) {
+		t.Fatalf("Bind = %v, want FieldErrors", err)
+	}
+	// All the failures are reported together, one per field
+	fields := map[string]string{}
+	for _, e := range fe {
+		if _, dup := fields[e.Field]; dup {
+			t.Errorf("field %q reported twice", e.Field)
+		}
+		fields[e.Field] = e.Source
+	}
+	want := map[string]string{"id": "path", "limit": "query", "kind": "query", "since": "query", "timeout": "query", "name": "body"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("failed fields = %v, want %v", fields, want)
+	}
+	for _, e := range fe {
+		if e.Field == "since" && !strings.Contains(e.Message, "2006-01-02") {
+			t.Errorf("since: %q does not tell the layout", e.Message)
+		}
+	}
+}
+
+func TestBindValidate(t *testing.T) {
+	type request struct {
+		Limit int    `query:"limit" binding:"max=100"`
+		Name  string `json:"name" binding:"required"`
+	}
+	req := httptest.NewRequest("POST", "/items/1?limit=200", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var got request
+	var fe FieldErrors
+	if err := bindTest(t, req, &got); !errors.As(err, &fe) {
+		t.Fatalf("Bind = %v, want FieldErrors", err)
+	}
+	want := FieldErrors{
+		{Field: "limit", Source: "query", Rule: "max", Message: "must be at most 100"},
+		{Field: "name", Source: "body", Rule: "required", Message: "is required"},
+	}
+	if !reflect.DeepEqual(fe, want) {
+		t.Errorf("errors = %+v, want %+v", fe, want)
+	}
+}
@@ -0,0 +1,30 @@
+package websvc
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit limits the size of request bodies to the given number of bytes.
+// Requests declaring a larger Content-Length are rejected with 413 immediately,
+// other bodies fail to read once the limit is exceeded, which is reported as 413 by the handler wrappers.
+func BodyLimit(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+// isBodyTooLarge checks whether the error is caused by exceeding the body limit
+func isBodyTooLarge(e error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(e, &mbe)
+}
@@ -0,0 +1,245 @@
+package websvc
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acsl-go/logger"
+)
+
+// CertStore holds the server certificates and the client CA pool, and allows them to be
+// reloaded at runtime without restarting the listener.
+// The certificates are served through the GetCertificate callback, selected by the SNI of the
+// client hello, and the client CA pool through the GetConfigForClient callback, so a reload
+// takes effect on the next handshake.
+type CertStore struct {
+	certs   []CertificateConfig
+	caCerts []string
+	base    *tls.Config
+
+	mu     sync.Mutex
+	state  atomic.Pointer[certState]
+	stamps map[string]fileStamp
+
+	// [Optional] Called after every reload attempt, err is nil if the new certificates were applied
+	OnReload func(err error)
+}
+
+type certState struct {
+	fallback  *tls.Certificate
+	names     map[string]*tls.Certificate
+	clientCAs *x509.CertPool
+	config    *tls.Config
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewCertStore creates a certificate store for the given certificates and client CAs.
+// The first certificate is used as the default when no host name matches the SNI.
+// Certificates, keys and CAs may be either file paths or PEM content, only file paths are watched for changes.
+// The base config is used as the template of the per-handshake config, it may be nil.
+func NewCertStore(certs []CertificateConfig, caCerts []string, base *tls.Config) *CertStore {
+	if base == nil {
+		base = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return &CertStore{
+		certs:   certs,
+		caCerts: caCerts,
+		base:    base,
+	}
+}
+
+// Reload loads the certificates again and swaps them in if they are valid.
+// The current certificates are kept if loading or validation fails.
+func (s *CertStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamps = s.currentStamps()
+	return s.reload()
+}
+
+func (s *CertStore) reload() error {
+	err := s.load()
+	if err != nil {
+		logger.Error("websvc:tls: reload certificates failed: %v", err)
+	} else {
+		logger.Info("websvc:tls: %d certificate(s) loaded", len(s.certs))
+	}
+	if s.OnReload != nil {
+		s.OnReload(err)
+	}
+	return err
+}
+
+func (s *CertStore) load() error {
+	if len(s.certs) == 0 {
+		return ErrInvalidTlsKeyPair
+	}
+
+	state := &certState{
+		names: make(map[string]*tls.Certificate),
+	}
+	for _, c := range s.certs {
+		cert, _, err := LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidTlsKeyPair, err)
+		}
+		if err := validateKeyPair(cert); err != nil {
+			return err
+		}
+		if state.fallback == nil {
+			state.fallback = cert
+		}
+
+		hostnames := c.Hostnames
+		if len(hostnames) == 0 {
+			hostnames = cert.Leaf.DNSNames
+			if len(hostnames) == 0 && cert.Leaf.Subject.CommonName != "" {
+				hostnames = []string{cert.Leaf.Subject.CommonName}
+			}
+		}
+		for _, name := range hostnames {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			// The first certificate claiming a name wins
+			if _, ok := state.names[name]; !ok {
+				state.names[name] = cert
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	for _, ca := range s.caCerts {
+		cacert, err := loadData(ca)
+		if err != nil {
+			return fmt.Errorf("failed to load CA certificate: %w", err)
+		}
+		pool.AppendCertsFromPEM(cacert)
+	}
+
+	state.clientCAs = pool
+	state.config = s.base.Clone()
+	state.config.Certificates = nil
+	state.config.GetCertificate = s.GetCertificate
+	state.config.GetConfigForClient = nil
+	state.config.ClientCAs = pool
+	s.state.Store(state)
+	return nil
+}
+
+// GetCertificate returns the certificate matching the SNI of the client hello,
+// it can be used as tls.Config.GetCertificate.
+// Exact host names are matched first, then wildcard names like "*.example.com",
+// the default certificate is returned if nothing matches.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	state := s.state.Load()
+	if state == nil {
+		return nil, ErrInvalidTlsKeyPair
+	}
+	if hello == nil || hello.ServerName == "" {
+		return state.fallback, nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := state.names[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := state.names["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return state.fallback, nil
+}
+
+// GetConfigForClient returns the config with the current certificates and client CAs,
+// it can be used as tls.Config.GetConfigForClient
+func (s *CertStore) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	state := s.state.Load()
+	if state == nil {
+		return nil, nil
+	}
+	return state.config, nil
+}
+
+// ClientCAs returns the current client CA pool
+func (s *CertStore) ClientCAs() *x509.CertPool {
+	state := s.state.Load()
+	if state == nil {
+		return nil
+	}
+	return state.clientCAs
+}
+
+// Watch checks the certificate files every interval and reloads them when changed.
+// It blocks until the context is canceled.
+func (s *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			stamps := s.currentStamps()
+			if !sameStamps(stamps, s.stamps) {
+				// Remember the stamps even if reloading fails, so a half-written pair is only
+				// reported once, and retried as soon as the other file changes.
+				s.stamps = stamps
+				s.reload()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *CertStore) currentStamps() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	files := make([]string, 0, len(s.certs)*2+len(s.caCerts))
+	for _, c := range s.certs {
+		files = append(files, c.Cert, c.Key)
+	}
+	files = append(files, s.caCerts...)
+	for _, f := range files {
+		if st, err := os.Stat(f); err == nil {
+			stamps[f] = fileStamp{size: st.Size(), modTime: st.ModTime()}
+		}
+	}
+	return stamps
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if o, ok := b[k]; !ok || o.size != v.size || !o.modTime.Equal(v.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateKeyPair checks that the private key matches the leaf certificate
+func validateKeyPair(cert *tls.Certificate) error {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok || cert.Leaf == nil {
+		return ErrInvalidTlsKeyPair
+	}
+	pub, ok := cert.Leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return ErrInvalidTlsKeyPair
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package websvc
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -47,7 +48,8 @@ func LoadCertsFromPEMData(certData []byte) ([]*x509.Certificate, [][]byte, error
 	certs := []*x509.Certificate{}
 	raw := [][]byte{}
 	for {
-		certBlock, certData := pem.Decode(certData)
+		var certBlock *pem.Block
+		certBlock, certData = pem.Decode(certData)
 		if certBlock == nil || certBlock.Type != "CERTIFICATE" {
 			return nil, nil, ErrInvalidCertificate
 		}
@@ -57,7 +59,7 @@ func LoadCertsFromPEMData(certData []byte) ([]*x509.Certificate, [][]byte, error
 		}
 		certs = append(certs, cert)
 		raw = append(raw, certBlock.Bytes)
-		if len(certData) == 0 {
+		if len(bytes.TrimSpace(certData)) == 0 {
 			break
 		}
 	}
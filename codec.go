@@ -0,0 +1,269 @@
+package websvc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes response data and decodes request bodies of a media type
+type Codec interface {
+	ContentType() string // The Content-Type of the encoded data
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// TypedCodec is implemented by codecs which can only encode some types, e.g. protobuf,
+// other codecs acceptable by the client are tried for the rest
+type TypedCodec interface {
+	Codec
+	Supports(v interface{}) bool
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return "application/json; charset=utf-8" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string                        { return "application/xml; charset=utf-8" }
+func (xmlCodec) Marshal(v interface{}) ([]byte, error)      { return xml.Marshal(v) }
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// ugorjiCodec serves MessagePack and CBOR with the codec gin depends on
+type ugorjiCodec struct {
+	contentType string
+	handle      codec.Handle
+}
+
+func (c *ugorjiCodec) ContentType() string {
+	return c.contentType
+}
+
+func (c *ugorjiCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(v)
+	return data, err
+}
+
+func (c *ugorjiCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Supports(v interface{}) bool {
+	_, ok := v.(proto.Message)
+	return ok
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+	return proto.Marshal(m)
+}
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// The *D wrappers pass a **pb.Msg for the usual TDATA = *pb.Msg, the message is allocated then
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Pointer ||
+			!rv.Elem().Type().Implements(protoMessageType) {
+			return ErrUnsupportedType
+		}
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		m = rv.Elem().Interface().(proto.Message)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// JSONCodec is the default codec, used when the client does not tell
+var JSONCodec Codec = jsonCodec{}
+
+// XMLCodec is not registered by default, since encoding/xml cannot encode maps and browsers accept XML before */*.
+// Register it to serve XML:
+//
+//	RegisterCodec(XMLCodec, "application/xml", "text/xml")
+var XMLCodec Codec = xmlCodec{}
+
+type registeredCodec struct {
+	mediaType string
+	codec     Codec
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   []registeredCodec // In the order of preference when the client accepts several
+)
+
+func init() {
+	RegisterCodec(JSONCodec, "application/json")
+	RegisterCodec(&ugorjiCodec{contentType: "application/msgpack", handle: &codec.MsgpackHandle{WriteExt: true}},
+		"application/msgpack", "application/x-msgpack", "application/vnd.msgpack")
+	RegisterCodec(&ugorjiCodec{contentType: "application/cbor", handle: &codec.CborHandle{}}, "application/cbor")
+	RegisterCodec(protobufCodec{}, "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf")
+}
+
+// RegisterCodec registers the codec for the media types, replacing the codecs registered for them before
+func RegisterCodec(c Codec, mediaTypes ...string) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, mt := range mediaTypes {
+		mt = strings.ToLower(mt)
+		i := slices.IndexFunc(codecs, func(rc registeredCodec) bool { return rc.mediaType == mt })
+		if i >= 0 {
+			codecs[i].codec = c
+		} else {
+			codecs = append(codecs, registeredCodec{mediaType: mt, codec: c})
+		}
+	}
+}
+
+// LookupCodec returns the codec registered for the media type, parameters are ignored
+func LookupCodec(mediaType string) Codec {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = mt
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, rc := range codecs {
+		if rc.mediaType == mediaType {
+			return rc.codec
+		}
+	}
+	return nil
+}
+
+type acceptedType struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media types of the Accept header ordered by preference
+func parseAccept(accept string) []acceptedType {
+	var types []acceptedType
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			types = append(types, acceptedType{mediaType: mt, q: q})
+		}
+	}
+	slices.SortStableFunc(types, func(a, b acceptedType) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	return types
+}
+
+// negotiateCodecs returns the codecs acceptable by the Accept header, in the order of preference.
+// JSON comes first wherever */* or application/* is acceptable.
+// It returns false if the client accepts none of the registered media types, then JSON is sent as well.
+func negotiateCodecs(accept string, v interface{}) ([]Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return nil, false
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	var candidates []Codec
+	matched := false
+	add := func(cd Codec) {
+		matched = true
+		if tc, ok := cd.(TypedCodec); ok && !tc.Supports(v) {
+			return
+		}
+		candidates = append(candidates, cd)
+	}
+	for _, at := range parseAccept(accept) {
+		if at.mediaType == "*/*" || at.mediaType == "application/*" {
+			add(JSONCodec)
+		}
+		for _, rc := range codecs {
+			if matchMediaType(at.mediaType, rc.mediaType) {
+				add(rc.codec)
+			}
+		}
+	}
+	return candidates, matched
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return false
+}
+
+// requestCodec selects the codec of the request body by the Content-Type header, JSON if the header is absent
+func requestCodec(c *gin.Context) (Codec, error) {
+	ct := c.GetHeader("Content-Type")
+	if ct == "" {
+		return JSONCodec, nil
+	}
+	if cd := LookupCodec(ct); cd != nil {
+		return cd, nil
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+// writeData encodes the data with the codec negotiated by the Accept header.
+// The next acceptable codec is tried if one cannot encode the data, 406 if none can, 500 if JSON fails.
+// JSON is sent if the header is absent or names no registered media type, as before negotiation.
+func writeData(c *gin.Context, code int, data interface{}) {
+	c.Header("Vary", "Accept")
+	candidates, matched := negotiateCodecs(c.GetHeader("Accept"), data)
+	if !matched {
+		candidates = []Codec{JSONCodec}
+	}
+	for _, cd := range candidates {
+		body, e := cd.Marshal(data)
+		if e != nil {
+			// Data JSON cannot encode is a bug of the handler rather than a choice of the client
+			if cd == JSONCodec {
+				abortWithError(c, e)
+				return
+			}
+			continue
+		}
+		c.Data(code, cd.ContentType(), body)
+		return
+	}
+	writeHTTPError(c, ErrNotAcceptable)
+}
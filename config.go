@@ -7,8 +7,68 @@ type Config struct {
 	SSLKey         string   `mapstructure:"ssl_key" json:"ssl_key" yaml:"ssl_key"`                            // [Optional] SSL Key content or file path
 	CACerts        []string `mapstructure:"ca_certs" json:"ca_certs" yaml:"ca_certs"`                         // [Optional] CA Certificates content or file path for verifying client certificates, only used when SSL is enabled
 	ClientAuthType string   `mapstructure:"client_auth_type" json:"client_auth_type" yaml:"client_auth_type"` // [Optional] Client authentication type, can be "none", "optional", "required", "must"
+
+	Network           string `mapstructure:"network" json:"network" yaml:"network"`                                     // [Optional] Listen network, can be "tcp", "tcp4", "tcp6", "unix", default is "tcp"
+	SocketPath        string `mapstructure:"socket_path" json:"socket_path" yaml:"socket_path"`                         // [Optional] Unix socket path, required when network is "unix"
+	SocketMode        string `mapstructure:"socket_mode" json:"socket_mode" yaml:"socket_mode"`                         // [Optional] Unix socket file permissions in octal, e.g. "0660", default is decided by umask
+	SystemdSocket     bool   `mapstructure:"systemd_socket" json:"systemd_socket" yaml:"systemd_socket"`                // [Optional] Adopt a listener passed by systemd socket activation (LISTEN_FDS) instead of binding, Host, Port and Network are ignored
+	SystemdSocketName string `mapstructure:"systemd_socket_name" json:"systemd_socket_name" yaml:"systemd_socket_name"` // [Optional] FileDescriptorName of the systemd socket to adopt, default is the first one not adopted yet
+
+	ProxyProtocol     bool     `mapstructure:"proxy_protocol" json:"proxy_protocol" yaml:"proxy_protocol"`                // [Optional] Parse the HAProxy PROXY protocol (v1 and v2) header of accepted connections, so the real client address is reported
+	ProxyTrustedCIDRs []string `mapstructure:"proxy_trusted_cidrs" json:"proxy_trusted_cidrs" yaml:"proxy_trusted_cidrs"` // [Optional] Networks allowed to send the PROXY header, headers from other peers are not parsed, required if ProxyProtocol is enabled
+
+	HTTP2         bool     `mapstructure:"http2" json:"http2" yaml:"http2"`                            // [Optional] Enable HTTP/2 over TLS, negotiated by ALPN
+	H2C           bool     `mapstructure:"h2c" json:"h2c" yaml:"h2c"`                                  // [Optional] Enable HTTP/2 cleartext on plain listeners, both prior knowledge and upgrade from HTTP/1.1 are supported
+	NextProtos    []string `mapstructure:"next_protos" json:"next_protos" yaml:"next_protos"`          // [Optional] ALPN protocols advertised on TLS listeners, default is ["h2", "http/1.1"] if HTTP2 is enabled, ["http/1.1"] otherwise
+	RedirectHTTPS bool     `mapstructure:"redirect_https" json:"redirect_https" yaml:"redirect_https"` // [Optional] Redirect all requests on this plain listener to the first HTTPS listener of the server
+	Listeners     []Config `mapstructure:"listeners" json:"listeners" yaml:"listeners"`                // [Optional] Additional listeners sharing the router of the server, only the listener related fields are used, e.g. host, port, network, ssl and certificates
+
+	Certificates []CertificateConfig `mapstructure:"certificates" json:"certificates" yaml:"certificates"` // [Optional] Additional certificates selected by SNI, SSLCert/SSLKey is used as the default if specified, otherwise the first one
+
+	CertReloadInterval int `mapstructure:"cert_reload_interval" json:"cert_reload_interval" yaml:"cert_reload_interval"` // [Optional] Interval in seconds to check certificate files for changes, default is 30, negative value disables watching
+
+	DrainTimeout  int `mapstructure:"drain_timeout" json:"drain_timeout" yaml:"drain_timeout"`    // [Optional] Time in seconds to wait for requests and websocket connections to finish on shutdown, default is 5
+	ShutdownDelay int `mapstructure:"shutdown_delay" json:"shutdown_delay" yaml:"shutdown_delay"` // [Optional] Time in seconds the server task keeps serving with the readiness endpoint failing before shutting down, so load balancers stop routing to it first, default is 0
+
+	ReadHeaderTimeout int   `mapstructure:"read_header_timeout" json:"read_header_timeout" yaml:"read_header_timeout"` // [Optional] Time in seconds allowed to read request headers, default is 10, negative value disables the timeout
+	ReadTimeout       int   `mapstructure:"read_timeout" json:"read_timeout" yaml:"read_timeout"`                      // [Optional] Time in seconds allowed to read the entire request including the body, default is no timeout
+	WriteTimeout      int   `mapstructure:"write_timeout" json:"write_timeout" yaml:"write_timeout"`                   // [Optional] Time in seconds allowed to write the response, default is no timeout
+	IdleTimeout       int   `mapstructure:"idle_timeout" json:"idle_timeout" yaml:"idle_timeout"`                      // [Optional] Time in seconds to keep idle keep-alive connections, default is the read timeout
+	MaxHeaderBytes    int   `mapstructure:"max_header_bytes" json:"max_header_bytes" yaml:"max_header_bytes"`          // [Optional] Maximum size of request headers in bytes, default is 1MB
+	MaxBodyBytes      int64 `mapstructure:"max_body_bytes" json:"max_body_bytes" yaml:"max_body_bytes"`                // [Optional] Maximum size of request bodies in bytes, requests exceeding it are rejected with 413, default is unlimited
+
+	LivenessPath  string `mapstructure:"liveness_path" json:"liveness_path" yaml:"liveness_path"`    // [Optional] Path of the liveness endpoint, e.g. "/healthz", disabled if empty
+	ReadinessPath string `mapstructure:"readiness_path" json:"readiness_path" yaml:"readiness_path"` // [Optional] Path of the readiness endpoint reporting the checks added by Server.AddReadinessCheck, e.g. "/readyz", disabled if empty
+	MetricsPath   string `mapstructure:"metrics_path" json:"metrics_path" yaml:"metrics_path"`       // [Optional] Path of the Prometheus metrics endpoint, e.g. "/metrics", HTTP request metrics are only collected if set
+	Tracing       bool   `mapstructure:"tracing" json:"tracing" yaml:"tracing"`                      // [Optional] Create a span for each request continuing the traceparent header, spans go to the global OpenTelemetry TracerProvider
+
+	AccessLog AccessLogConfig `mapstructure:"access_log" json:"access_log" yaml:"access_log"` // [Optional] Structured access log with request IDs
+}
+
+type CertificateConfig struct {
+	Cert      string   `mapstructure:"cert" json:"cert" yaml:"cert"`                // SSL Certificate content or file path
+	Key       string   `mapstructure:"key" json:"key" yaml:"key"`                   // SSL Key content or file path
+	Hostnames []string `mapstructure:"hostnames" json:"hostnames" yaml:"hostnames"` // [Optional] Host names served by this certificate, wildcards like "*.example.com" are allowed, derived from the certificate SANs if not specified
+}
+
+type AccessLogConfig struct {
+	Enabled       bool     `mapstructure:"enabled" json:"enabled" yaml:"enabled"`                      // [Optional] Enable the access log, it is independent of the log level
+	SampleRate    float64  `mapstructure:"sample_rate" json:"sample_rate" yaml:"sample_rate"`          // [Optional] Fraction of successful requests logged, between 0 and 1, requests with status >= 400 are always logged, default is 1
+	Headers       []string `mapstructure:"headers" json:"headers" yaml:"headers"`                      // [Optional] Request headers included in the log
+	RedactHeaders []string `mapstructure:"redact_headers" json:"redact_headers" yaml:"redact_headers"` // [Optional] Headers logged as "[REDACTED]", default is Authorization and Cookie
+	RedactQuery   []string `mapstructure:"redact_query" json:"redact_query" yaml:"redact_query"`       // [Optional] Query parameters logged as "[REDACTED]"
+	SkipPaths     []string `mapstructure:"skip_paths" json:"skip_paths" yaml:"skip_paths"`             // [Optional] Paths not logged, e.g. health checks
 }
 
 func (c *Config) IsSSL() bool {
-	return c.SSLCert != "" && c.SSLKey != ""
+	return (c.SSLCert != "" && c.SSLKey != "") || len(c.Certificates) > 0
+}
+
+// AllCertificates returns the default certificate followed by the SNI certificates
+func (c *Config) AllCertificates() []CertificateConfig {
+	certs := make([]CertificateConfig, 0, len(c.Certificates)+1)
+	if c.SSLCert != "" && c.SSLKey != "" {
+		certs = append(certs, CertificateConfig{Cert: c.SSLCert, Key: c.SSLKey})
+	}
+	return append(certs, c.Certificates...)
 }
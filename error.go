@@ -8,4 +8,13 @@ var (
 	ErrInvalidPrivateKey  = errors.New("invalid private key")
 	ErrInvalidCertificate = errors.New("invalid certificate")
 	ErrIOFailure          = errors.New("I/O failure")
+	ErrTLSNotEnabled      = errors.New("TLS is not enabled")
+
+	ErrNoInheritedListener = errors.New("no inherited listener available")
+	ErrInvalidProxyHeader  = errors.New("invalid PROXY protocol header")
+	ErrNoProxyTrustedCIDRs = errors.New("proxy_protocol requires proxy_trusted_cidrs")
+	ErrUnsupportedType     = errors.New("type not supported by the codec")
+	ErrStreamClosed        = errors.New("stream closed")
+	ErrInvalidSSEField     = errors.New("SSE event ID and type must not contain line breaks")
+	ErrNonceStoreFull      = errors.New("nonce store full")
 )
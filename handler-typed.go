@@ -0,0 +1,132 @@
+package websvc
+
+import (
+	"reflect"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// HandlerSpec describes the request and response of a typed handler, for documentation and testing
+type HandlerSpec struct {
+	Session     reflect.Type // The session type of authenticated handlers, nil otherwise
+	Request     reflect.Type // The request body type
+	Response    reflect.Type // The response body type
+	ContentType string       // The response content type, empty if it is decided per response (Response or *Response)
+}
+
+// SpecOf reflects the spec from the signature of a typed handler function, that is
+// func(*gin.Context, TReq) (int, TResp, error) or func(*gin.Context, TSES, TReq) (int, TResp, error).
+// It returns false for other functions.
+func SpecOf(handler interface{}) (HandlerSpec, bool) {
+	var spec HandlerSpec
+	t := reflect.TypeOf(handler)
+	if t == nil || t.Kind() != reflect.Func || t.NumOut() != 3 || t.NumIn() < 2 || t.NumIn() > 3 {
+		return spec, false
+	}
+	if t.In(0) != reflect.TypeOf(&gin.Context{}) || t.Out(0).Kind() != reflect.Int || t.Out(2) != reflect.TypeOf((*error)(nil)).Elem() {
+		return spec, false
+	}
+	if t.NumIn() == 3 {
+		spec.Session = t.In(1)
+	}
+	spec.Request = t.In(t.NumIn() - 1)
+	spec.Response = t.Out(1)
+	spec.ContentType = contentTypeOf(spec.Response)
+	return spec, true
+}
+
+var (
+	stringType   = reflect.TypeOf("")
+	bytesType    = reflect.TypeOf([]byte(nil))
+	responseType = reflect.TypeOf(Response{})
+)
+
+func contentTypeOf(t reflect.Type) string {
+	switch t {
+	case stringType:
+		return "text/plain; charset=utf-8"
+	case bytesType:
+		return "application/octet-stream"
+	case responseType, reflect.PointerTo(responseType):
+		return ""
+	}
+	return JSONCodec.ContentType() // By default, negotiated by the Accept header
+}
+
+// HandlerT is the typed equivalent of HandlerD, the response type is checked at compile time.
+// The request is bound with Bind, so TReq may take path parameters, query, headers and cookies as well,
+// and the body is optional.
+// A nil response (nil pointer, slice or map) sends the status code without body.
+func HandlerT[TReq interface{}, TResp interface{}](handler func(*gin.Context, TReq) (int, TResp, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var data TReq
+		if e := Bind(c, &data); e != nil {
+			abortWithError(c, e)
+			return
+		}
+
+		code, rsp, e := handler(c, data)
+		if e != nil {
+			abortWithError(c, e)
+			return
+		}
+
+		processRespT(c, code, rsp)
+	}
+}
+
+// AuthT is the typed equivalent of AuthD, the request is bound as for HandlerT
+func AuthT[TSES interface{}, TReq interface{}, TResp interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES, TReq) (int, TResp, error), privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ses_obj, _, data_bytes, code := doAuth(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+
+		ses, ok := ses_obj.(TSES)
+		if !ok {
+			logger.Fatal("Auth data type miss-match in %s", c.Request.URL.Path)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		var data TReq
+		if e := bindRequest(c, &data, data_bytes); e != nil {
+			abortWithError(c, e)
+			return
+		}
+
+		code, rsp, e := handler(c, ses, data)
+		if e != nil {
+			abortWithError(c, e)
+			return
+		}
+
+		processRespT(c, code, rsp)
+	}
+}
+
+func processRespT[TResp interface{}](c *gin.Context, code int, rsp TResp) {
+	v := reflect.ValueOf(&rsp).Elem()
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		if v.IsNil() {
+			c.AbortWithStatus(code)
+			return
+		}
+	}
+	switch r := any(rsp).(type) {
+	case Response:
+		writeResponse(c, code, &r)
+	case *Response:
+		writeResponse(c, code, r)
+	case string:
+		c.String(code, r)
+	case []byte:
+		c.Data(code, "application/octet-stream", r)
+	default:
+		writeData(c, code, r)
+	}
+}
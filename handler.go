@@ -1,7 +1,8 @@
 package websvc
 
 import (
-	"github.com/acsl-go/logger"
+	"net/url"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,8 +10,7 @@ func Handler(handler func(*gin.Context) (int, interface{}, error)) gin.HandlerFu
 	return func(c *gin.Context) {
 		code, rsp, e := handler(c)
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -21,16 +21,18 @@ func Handler(handler func(*gin.Context) (int, interface{}, error)) gin.HandlerFu
 func HandlerD[TDATA interface{}](handler func(*gin.Context, TDATA) (int, interface{}, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var data TDATA
-		if e := c.ShouldBindJSON(&data); e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(400)
+		body, e := readBody(c)
+		if e == nil {
+			e = decodeBody(c, body, &data)
+		}
+		if e != nil {
+			abortWithError(c, e)
 			return
 		}
 
 		code, rsp, e := handler(c, data)
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -41,16 +43,35 @@ func HandlerD[TDATA interface{}](handler func(*gin.Context, TDATA) (int, interfa
 func HandlerQ(handler func(*gin.Context, map[string]string) (int, interface{}, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		queries, _, e := parseQuery(c)
+		queries := parseQuery(c)
+
+		code, rsp, e := handler(c, flattenQuery(queries))
 		if e != nil {
-			c.AbortWithStatus(400)
+			abortWithError(c, e)
 			return
 		}
 
-		code, rsp, e := handler(c, queries)
+		processResp(c, code, rsp)
+	}
+}
+
+func HandlerQD[TDATA interface{}](handler func(*gin.Context, map[string]string, TDATA) (int, interface{}, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queries := parseQuery(c)
+
+		var data TDATA
+		body, e := readBody(c)
+		if e == nil {
+			e = decodeBody(c, body, &data)
+		}
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
+			return
+		}
+
+		code, rsp, e := handler(c, flattenQuery(queries), data)
+		if e != nil {
+			abortWithError(c, e)
 			return
 		}
 
@@ -58,25 +79,39 @@ func HandlerQ(handler func(*gin.Context, map[string]string) (int, interface{}, e
 	}
 }
 
-func HandlerQD[TDATA interface{}](handler func(*gin.Context, map[string]string, TDATA) (int, interface{}, error)) gin.HandlerFunc {
+// HandlerQM is HandlerQ with all the values of repeated query keys
+func HandlerQM(handler func(*gin.Context, url.Values) (int, interface{}, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		queries, _, e := parseQuery(c)
+		queries := parseQuery(c)
+
+		code, rsp, e := handler(c, queries)
 		if e != nil {
-			c.AbortWithStatus(400)
+			abortWithError(c, e)
 			return
 		}
 
+		processResp(c, code, rsp)
+	}
+}
+
+// HandlerQDM is HandlerQD with all the values of repeated query keys
+func HandlerQDM[TDATA interface{}](handler func(*gin.Context, url.Values, TDATA) (int, interface{}, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queries := parseQuery(c)
+
 		var data TDATA
-		if e := c.ShouldBindJSON(&data); e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(400)
+		body, e := readBody(c)
+		if e == nil {
+			e = decodeBody(c, body, &data)
+		}
+		if e != nil {
+			abortWithError(c, e)
 			return
 		}
 
 		code, rsp, e := handler(c, queries, data)
 		if e != nil {
-			logger.Error("Error: %+v", e)
-			c.AbortWithStatus(500)
+			abortWithError(c, e)
 			return
 		}
 
@@ -0,0 +1,151 @@
+package websvc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The default timeout of a readiness check
+const defaultHealthCheckTimeout = 5 * time.Second
+
+// HealthCheck reports whether a dependency is ready, it should return before the context is done
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckResult is the status of one readiness check
+type HealthCheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // "ok" or "fail"
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the response body of the liveness and readiness endpoints
+type HealthReport struct {
+	Status string              `json:"status"` // "ok" or "fail"
+	Reason string              `json:"reason,omitempty"`
+	Checks []HealthCheckResult `json:"checks,omitempty"`
+}
+
+type namedHealthCheck struct {
+	name    string
+	timeout time.Duration
+	check   HealthCheck
+}
+
+// healthRegistry holds the readiness checks of a server
+type healthRegistry struct {
+	mu           sync.RWMutex
+	checks       []*namedHealthCheck
+	shuttingDown atomic.Bool
+}
+
+func newHealthRegistry() *healthRegistry {
+	return &healthRegistry{}
+}
+
+// add registers a readiness check, a check with the same name is replaced
+func (r *healthRegistry) add(name string, timeout time.Duration, check HealthCheck) {
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.checks {
+		if c.name == name {
+			r.checks[i] = &namedHealthCheck{name: name, timeout: timeout, check: check}
+			return
+		}
+	}
+	r.checks = append(r.checks, &namedHealthCheck{name: name, timeout: timeout, check: check})
+}
+
+func (r *healthRegistry) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.checks {
+		if c.name == name {
+			r.checks = append(r.checks[:i], r.checks[i+1:]...)
+			return
+		}
+	}
+}
+
+// run runs all checks concurrently, each one within its own timeout
+func (r *healthRegistry) run(ctx context.Context) HealthReport {
+	r.mu.RLock()
+	checks := make([]*namedHealthCheck, len(r.checks))
+	copy(checks, r.checks)
+	r.mu.RUnlock()
+
+	report := HealthReport{
+		Status: "ok",
+		Checks: make([]HealthCheckResult, len(checks)),
+	}
+	if r.shuttingDown.Load() {
+		report.Status = "fail"
+		report.Reason = "shutting down"
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *namedHealthCheck) {
+			defer wg.Done()
+			report.Checks[i] = runHealthCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, res := range report.Checks {
+		if res.Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+func runHealthCheck(ctx context.Context, c *namedHealthCheck) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := HealthCheckResult{
+		Name:      c.name,
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = "fail"
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func (r *healthRegistry) livenessHandler(c *gin.Context) {
+	c.JSON(200, HealthReport{Status: "ok"})
+}
+
+func (r *healthRegistry) readinessHandler(c *gin.Context) {
+	report := r.run(c.Request.Context())
+	if report.Status != "ok" {
+		c.JSON(503, report)
+	} else {
+		c.JSON(200, report)
+	}
+}
@@ -0,0 +1,184 @@
+package websvc
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPError is an error carrying the response to send, handlers return it to fail a request
+// with a status other than 500, the wrappers recognize it with errors.As, so it can be wrapped.
+type HTTPError struct {
+	Status  int         // HTTP status code
+	Code    string      // Machine readable error code, e.g. "user_not_found"
+	Message string      // Human readable message, sent to the client
+	Details interface{} // [Optional] Additional data, sent to the client
+	Err     error       // [Optional] The cause, logged but never sent to the client
+}
+
+func NewHTTPError(status int, code, message string) *HTTPError {
+	return &HTTPError{Status: status, Code: code, Message: message}
+}
+
+func (e *HTTPError) Error() string {
+	msg := http.StatusText(e.Status)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// WithDetails returns a copy of the error with the details set
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// Wrap returns a copy of the error with the cause set
+func (e *HTTPError) Wrap(err error) *HTTPError {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// Common errors
+var (
+	ErrBadRequest           = NewHTTPError(http.StatusBadRequest, "bad_request", "Bad Request")
+	ErrInvalidRequest       = NewHTTPError(http.StatusBadRequest, "invalid_request", "Invalid request parameters")
+	ErrUnauthorized         = NewHTTPError(http.StatusUnauthorized, "unauthorized", "Unauthorized")
+	ErrForbidden            = NewHTTPError(http.StatusForbidden, "forbidden", "Forbidden")
+	ErrNotFound             = NewHTTPError(http.StatusNotFound, "not_found", "Not Found")
+	ErrNotAcceptable        = NewHTTPError(http.StatusNotAcceptable, "not_acceptable", "Not Acceptable")
+	ErrConflict             = NewHTTPError(http.StatusConflict, "conflict", "Conflict")
+	ErrPayloadTooLarge      = NewHTTPError(http.StatusRequestEntityTooLarge, "payload_too_large", "Payload Too Large")
+	ErrUnsupportedMediaType = NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported Media Type")
+	ErrTooManyRequests      = NewHTTPError(http.StatusTooManyRequests, "too_many_requests", "Too Many Requests")
+	ErrInternal             = NewHTTPError(http.StatusInternalServerError, "internal_error", "Internal Server Error")
+	ErrUnavailable          = NewHTTPError(http.StatusServiceUnavailable, "unavailable", "Service Unavailable")
+)
+
+// ProblemDetails makes error responses use RFC 7807 application/problem+json for all clients,
+// otherwise it is only used for clients accepting application/problem+json but not application/json
+var ProblemDetails bool
+
+type errorMapping struct {
+	target error
+	err    *HTTPError
+}
+
+var (
+	errorMappingsMu sync.RWMutex
+	errorMappings   = []errorMapping{
+		{target: ErrNoPrivilege, err: ErrForbidden},
+	}
+)
+
+// RegisterError maps errors matching target with errors.Is to the HTTP error,
+// so applications can return their own sentinel errors from handlers.
+// Mappings registered later take precedence.
+func RegisterError(target error, err *HTTPError) {
+	errorMappingsMu.Lock()
+	defer errorMappingsMu.Unlock()
+	errorMappings = append(errorMappings, errorMapping{target: target, err: err})
+}
+
+// AsHTTPError finds the HTTP error for err, either an HTTPError in its chain or a registered mapping
+func AsHTTPError(err error) (*HTTPError, bool) {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he, true
+	}
+	errorMappingsMu.RLock()
+	defer errorMappingsMu.RUnlock()
+	for i := len(errorMappings) - 1; i >= 0; i-- {
+		if errors.Is(err, errorMappings[i].target) {
+			return errorMappings[i].err.Wrap(err), true
+		}
+	}
+	return nil, false
+}
+
+// errorBody is the JSON body of error responses
+type errorBody struct {
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// problemBody is the RFC 7807 body of error responses, code, details and request_id are extension members
+type problemBody struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      string      `json:"code"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// abortWithError responds with the HTTP error of e, errors without one are logged and sent as ErrInternal
+func abortWithError(c *gin.Context, e error) {
+	he, ok := AsHTTPError(e)
+	if !ok {
+		he = ErrInternal.Wrap(e)
+	}
+	// Only server errors are failures of the request, client errors are expected
+	if he.Status >= 500 || he.Status == 0 {
+		logger.Error("Error: %+v", e)
+		c.Error(e)
+	} else {
+		logger.Debug("Error: %+v", e)
+	}
+	writeHTTPError(c, he)
+}
+
+// writeHTTPError aborts the request and sends the error body
+func writeHTTPError(c *gin.Context, he *HTTPError) {
+	status := he.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	if ProblemDetails || prefersProblemJSON(c.GetHeader("Accept")) {
+		c.Header("Content-Type", "application/problem+json; charset=utf-8")
+		c.AbortWithStatusJSON(status, problemBody{
+			Type:      "about:blank",
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    he.Message,
+			Instance:  c.Request.URL.Path,
+			Code:      he.Code,
+			Details:   he.Details,
+			RequestID: RequestID(c),
+		})
+		return
+	}
+	c.AbortWithStatusJSON(status, errorBody{
+		Status:    status,
+		Code:      he.Code,
+		Message:   he.Message,
+		Details:   he.Details,
+		RequestID: RequestID(c),
+	})
+}
+
+func prefersProblemJSON(accept string) bool {
+	return strings.Contains(accept, "application/problem+json") && !strings.Contains(accept, "application/json")
+}
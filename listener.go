@@ -0,0 +1,124 @@
+package websvc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+type inheritedFd struct {
+	fd      int
+	name    string
+	adopted bool
+}
+
+var (
+	inheritedFdsOnce sync.Once
+	inheritedFdsMu   sync.Mutex
+	inheritedFds     []*inheritedFd
+)
+
+// loadInheritedFds parses LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES set by systemd,
+// the variables are unset afterwards so they are not passed to child processes.
+func loadInheritedFds() {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < nfds; i++ {
+		fd := listenFdsStart + i
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		inheritedFds = append(inheritedFds, &inheritedFd{fd: fd, name: name})
+	}
+}
+
+// adoptInheritedListener takes over a listener passed by systemd socket activation.
+// If name is empty, the first listener not adopted yet is used, otherwise the one with the matching FileDescriptorName.
+func adoptInheritedListener(name string) (net.Listener, error) {
+	inheritedFdsOnce.Do(loadInheritedFds)
+
+	inheritedFdsMu.Lock()
+	defer inheritedFdsMu.Unlock()
+	for _, f := range inheritedFds {
+		if f.adopted || (name != "" && f.name != name) {
+			continue
+		}
+		file := os.NewFile(uintptr(f.fd), f.name)
+		listener, err := net.FileListener(file)
+		file.Close() // FileListener dups the descriptor
+		if err != nil {
+			return nil, err
+		}
+		f.adopted = true
+		return listener, nil
+	}
+	return nil, ErrNoInheritedListener
+}
+
+// listen creates the raw listener described by the config
+func listen(config *Config) (net.Listener, error) {
+	if config.SystemdSocket {
+		return adoptInheritedListener(config.SystemdSocketName)
+	}
+
+	network := config.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(network, fmt.Sprintf("%s:%d", config.Host, config.Port))
+	case "unix":
+		return listenUnix(config.SocketPath, config.SocketMode)
+	default:
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+}
+
+func listenUnix(path, mode string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("socket_path is required for unix network")
+	}
+
+	// Remove the stale socket file left by a previous run, other files are kept untouched
+	if st, err := os.Lstat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid socket_mode: %s", mode)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
@@ -0,0 +1,82 @@
+package websvc
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Built-in metrics, recorded in DefaultMetrics
+var (
+	httpRequestsTotal = DefaultMetrics.NewCounter("websvc_http_requests_total",
+		"Total number of HTTP requests by method, route template and status code.", "method", "route", "status")
+	httpRequestDuration = DefaultMetrics.NewHistogram("websvc_http_request_duration_seconds",
+		"HTTP request latencies in seconds by method and route template.", nil, "method", "route")
+	authTotal = DefaultMetrics.NewCounter("websvc_auth_total",
+		"Total number of authentication attempts by status code and reason.", "status", "reason")
+	wsConnections = DefaultMetrics.NewGauge("websvc_websocket_connections",
+		"Number of live websocket connections.").With()
+	wsMessages = DefaultMetrics.NewCounter("websvc_websocket_messages_total",
+		"Total number of websocket messages by direction.", "direction")
+	wsBytes = DefaultMetrics.NewCounter("websvc_websocket_bytes_total",
+		"Total number of websocket payload bytes by direction.", "direction")
+	wsSendingQueue = DefaultMetrics.NewGauge("websvc_websocket_sending_queue",
+		"Number of messages waiting in the sending queues of all websocket connections.").With()
+	wsBeatTimeouts = DefaultMetrics.NewCounter("websvc_websocket_heartbeat_timeouts_total",
+		"Total number of websocket connections closed by heartbeat timeout.").With()
+	panicsTotal = DefaultMetrics.NewCounter("websvc_panics_total",
+		"Total number of recovered panics by source.", "source")
+
+	wsMessagesIn  = wsMessages.With("in")
+	wsMessagesOut = wsMessages.With("out")
+	wsBytesIn     = wsBytes.With("in")
+	wsBytesOut    = wsBytes.With("out")
+)
+
+// MetricsMiddleware records the count, latency and status code of requests per route template
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := metricMethod(c.Request.Method)
+		httpRequestsTotal.With(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.With(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricMethod maps the methods outside the standard set to "OTHER",
+// so clients cannot create series without bound
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// MetricsHandler serves DefaultMetrics in the Prometheus text format
+func MetricsHandler() gin.HandlerFunc {
+	return DefaultMetrics.Handler()
+}
+
+// authResult records the outcome of an authentication in the metrics and the auth span,
+// and returns the status code, code 0 means the authentication succeeded
+func authResult(span trace.Span, code int, reason string) int {
+	status := "ok"
+	if code != 0 {
+		status = strconv.Itoa(code)
+	}
+	authTotal.With(status, reason).Inc()
+	span.SetAttributes(attribute.String("auth.status", status), attribute.String("auth.reason", reason))
+	return code
+}
@@ -0,0 +1,347 @@
+package websvc
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsRegistry holds metric families and renders them in the Prometheus text exposition format
+type MetricsRegistry struct {
+	mu       sync.RWMutex
+	families []metricFamily
+}
+
+type metricFamily interface {
+	write(w *bufio.Writer)
+}
+
+// DefaultMetrics is the registry the built-in HTTP, auth and websocket metrics are recorded in
+var DefaultMetrics = NewMetricsRegistry()
+
+// DefaultBuckets are the default histogram buckets in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+func (r *MetricsRegistry) register(f metricFamily) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// NewCounter registers a counter family with the given label names
+func (r *MetricsRegistry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newMetricVec[metricValue](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewGauge registers a gauge family with the given label names
+func (r *MetricsRegistry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newMetricVec[metricValue](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers a histogram family with the given upper bounds and label names,
+// DefaultBuckets is used if buckets is empty
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	h := &HistogramVec{vec: newMetricVec[histogramValue](name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	families := slices.Clone(r.families)
+	r.mu.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns a handler serving the metrics, it can be mounted on any route
+func (r *MetricsRegistry) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(200)
+		r.WriteTo(c.Writer)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// metricVec holds the series of a family, keyed by the label values
+type metricVec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.RWMutex
+	series map[string]*metricSeries[T]
+}
+
+type metricSeries[T any] struct {
+	labels []string
+	value  *T
+}
+
+func newMetricVec[T any](name, help, kind string, labels []string) *metricVec[T] {
+	return &metricVec[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*metricSeries[T]),
+	}
+}
+
+func (v *metricVec[T]) with(values []string, create func() *T) *T {
+	if len(values) != len(v.labels) {
+		panic("websvc: metric " + v.name + " label count mismatch")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s.value
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s.value
+	}
+	s = &metricSeries[T]{labels: slices.Clone(values), value: create()}
+	v.series[key] = s
+	return s.value
+}
+
+// sorted returns the series ordered by label values, so the output is stable
+func (v *metricVec[T]) sorted() []*metricSeries[T] {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	series := make([]*metricSeries[T], 0, len(keys))
+	for _, k := range keys {
+		series = append(series, v.series[k])
+	}
+	v.mu.RUnlock()
+	return series
+}
+
+func (v *metricVec[T]) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + v.name + " " + escapeHelp(v.help) + "\n")
+	w.WriteString("# TYPE " + v.name + " " + v.kind + "\n")
+}
+
+// metricValue is a float64 updated atomically
+type metricValue struct {
+	bits atomic.Uint64
+}
+
+func (m *metricValue) Add(delta float64) {
+	for {
+		old := m.bits.Load()
+		if m.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (m *metricValue) Set(value float64) {
+	m.bits.Store(math.Float64bits(value))
+}
+
+func (m *metricValue) Inc() {
+	m.Add(1)
+}
+
+func (m *metricValue) Dec() {
+	m.Add(-1)
+}
+
+func (m *metricValue) Value() float64 {
+	return math.Float64frombits(m.bits.Load())
+}
+
+// Counter is a monotonically increasing value
+type Counter interface {
+	Inc()
+	Add(delta float64)
+	Value() float64
+}
+
+// Gauge is a value that can go up and down
+type Gauge interface {
+	Inc()
+	Dec()
+	Add(delta float64)
+	Set(value float64)
+	Value() float64
+}
+
+type CounterVec struct {
+	vec *metricVec[metricValue]
+}
+
+// With returns the counter for the given label values
+func (c *CounterVec) With(values ...string) Counter {
+	return c.vec.with(values, func() *metricValue { return &metricValue{} })
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.vec.writeHeader(w)
+	for _, s := range c.vec.sorted() {
+		writeSample(w, c.vec.name, c.vec.labels, s.labels, "", "", s.value.Value())
+	}
+}
+
+type GaugeVec struct {
+	vec *metricVec[metricValue]
+}
+
+// With returns the gauge for the given label values
+func (g *GaugeVec) With(values ...string) Gauge {
+	return g.vec.with(values, func() *metricValue { return &metricValue{} })
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.vec.writeHeader(w)
+	for _, s := range g.vec.sorted() {
+		writeSample(w, g.vec.name, g.vec.labels, s.labels, "", "", s.value.Value())
+	}
+}
+
+// histogramValue counts observations per bucket, the counts are not cumulative
+type histogramValue struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	sum     metricValue
+	count   atomic.Uint64
+}
+
+func (h *histogramValue) Observe(value float64) {
+	i, _ := slices.BinarySearch(h.buckets, value)
+	h.counts[i].Add(1)
+	h.sum.Add(value)
+	h.count.Add(1)
+}
+
+// Histogram samples observations into buckets
+type Histogram interface {
+	Observe(value float64)
+}
+
+type HistogramVec struct {
+	vec     *metricVec[histogramValue]
+	buckets []float64
+}
+
+// With returns the histogram for the given label values
+func (h *HistogramVec) With(values ...string) Histogram {
+	return h.vec.with(values, func() *histogramValue {
+		return &histogramValue{
+			buckets: h.buckets,
+			counts:  make([]atomic.Uint64, len(h.buckets)+1), // The last one is +Inf
+		}
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.vec.writeHeader(w)
+	for _, s := range h.vec.sorted() {
+		cumulative := uint64(0)
+		for i, b := range h.buckets {
+			cumulative += s.value.counts[i].Load()
+			writeSample(w, h.vec.name+"_bucket", h.vec.labels, s.labels, "le", formatFloat(b), float64(cumulative))
+		}
+		cumulative += s.value.counts[len(h.buckets)].Load()
+		writeSample(w, h.vec.name+"_bucket", h.vec.labels, s.labels, "le", "+Inf", float64(cumulative))
+		writeSample(w, h.vec.name+"_sum", h.vec.labels, s.labels, "", "", s.value.sum.Value())
+		writeSample(w, h.vec.name+"_count", h.vec.labels, s.labels, "", "", float64(s.value.count.Load()))
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, names, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(names) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, n := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(n + "=\"" + escapeLabel(values[i]) + "\"")
+		}
+		if extraName != "" {
+			if len(names) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + "=\"" + extraValue + "\"")
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,233 @@
+package websvc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acsl-go/logger"
+)
+
+// The time allowed for the peer to send the PROXY protocol header
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener parses the HAProxy PROXY protocol (v1 and v2) header of the accepted connections,
+// the header is only honored for peers in the trusted networks, connections from other peers are
+// passed through untouched.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// newProxyListener wraps the listener, the trusted networks must be given, since a PROXY header from any
+// other peer would let it spoof its address. Use 0.0.0.0/0 and ::/0 to trust everyone explicitly.
+func newProxyListener(listener net.Listener, trustedCIDRs []string) (net.Listener, error) {
+	if len(trustedCIDRs) == 0 {
+		return nil, ErrNoProxyTrustedCIDRs
+	}
+	trusted := make([]*net.IPNet, 0, len(trustedCIDRs))
+	for _, cidr := range trustedCIDRs {
+		if !strings.Contains(cidr, "/") {
+			// Accept bare addresses as single host networks
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_trusted_cidrs: %s", cidr)
+		}
+		trusted = append(trusted, ipnet)
+	}
+	return &proxyListener{
+		Listener: listener,
+		trusted:  trusted,
+	}, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+// isTrusted checks whether the peer is allowed to send a PROXY header
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		// Unix sockets are local
+		return true
+	}
+	for _, ipnet := range l.trusted {
+		if ipnet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the PROXY header lazily, on the first Read or RemoteAddr call,
+// so a slow peer does not block the accept loop.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	sig, err := c.reader.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		err = c.readV2()
+	} else if err == nil && bytes.HasPrefix(sig, []byte("PROXY ")) {
+		err = c.readV1()
+	} else if err == nil {
+		err = ErrInvalidProxyHeader
+	}
+	if err != nil {
+		logger.Debug("websvc:proxy: read header from %s failed: %v", c.Conn.RemoteAddr(), err)
+		c.err = err
+		c.Conn.Close()
+	}
+}
+
+// readV1 parses the text header, e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func (c *proxyConn) readV1() error {
+	// The v1 header is at most 107 bytes including the CRLF
+	line := make([]byte, 0, 107)
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= 107 {
+			return ErrInvalidProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrInvalidProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return ErrInvalidProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		// The connection was not proxied, e.g. health checks from the balancer
+		return nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return ErrInvalidProxyHeader
+		}
+		srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+		srcPort, e1 := strconv.ParseUint(fields[4], 10, 16)
+		dstPort, e2 := strconv.ParseUint(fields[5], 10, 16)
+		if srcIP == nil || dstIP == nil || e1 != nil || e2 != nil {
+			return ErrInvalidProxyHeader
+		}
+		c.remoteAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+		c.localAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+		return nil
+	default:
+		return ErrInvalidProxyHeader
+	}
+}
+
+// readV2 parses the binary header
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if header[12]>>4 != 2 {
+		return ErrInvalidProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13] >> 4
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+
+	switch command {
+	case 0x0:
+		// LOCAL, the connection was established by the proxy itself
+		return nil
+	case 0x1:
+		// PROXY
+	default:
+		return ErrInvalidProxyHeader
+	}
+
+	switch family {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return ErrInvalidProxyHeader
+		}
+		c.remoteAddr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		c.localAddr = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return ErrInvalidProxyHeader
+		}
+		c.remoteAddr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		c.localAddr = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	default:
+		// AF_UNSPEC and AF_UNIX carry no usable client address
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package websvc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2Header builds a v2 header with the command, the family and the payload of the addresses
+func proxyV2Header(command, family byte, payload []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family<<4|0x1, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func proxyV2IPv4() []byte {
+	payload := []byte{192, 168, 0, 1, 192, 168, 0, 11, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(payload[8:10], 56324)
+	binary.BigEndian.PutUint16(payload[10:12], 443)
+	return payload
+}
+
+func proxyV2IPv6() []byte {
+	payload := make([]byte, 36)
+	copy(payload[0:16], net.ParseIP("2001:db8::1"))
+	copy(payload[16:32], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(payload[32:34], 56324)
+	binary.BigEndian.PutUint16(payload[34:36], 443)
+	return payload
+}
+
+func TestProxyHeader(t *testing.T) {
+	unix := make([]byte, 216)
+	copy(unix, "/run/src.sock")
+	copy(unix[108:], "/run/dst.sock")
+	withTLV := append(proxyV2IPv4(), 0x04, 0x00, 0x01, 0x00) // PP2_TYPE_NOOP
+
+	tests := []struct {
+		name    string
+		header  []byte
+		remote  string // "pipe" if the address of the connection is kept
+		local   string
+		wantErr bool
+	}{
+		{name: "v1 TCP4", header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"), remote: "192.168.0.1:56324", local: "192.168.0.11:443"},
+		{name: "v1 TCP6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:443"},
+		{name: "v1 UNKNOWN", header: []byte("PROXY UNKNOWN\r\n"), remote: "pipe", local: "pipe"},
+		{name: "v1 UNKNOWN with addresses", header: []byte("PROXY UNKNOWN 2001:db8::1 2001:db8::2 56324 443\r\n"), remote: "pipe", local: "pipe"},
+		{name: "v2 PROXY IPv4", header: proxyV2Header(0x1, 0x1, proxyV2IPv4()), remote: "192.168.0.1:56324", local: "192.168.0.11:443"},
+		{name: "v2 PROXY IPv6", header: proxyV2Header(0x1, 0x2, proxyV2IPv6()), remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:443"},
+		{name: "v2 PROXY unix", header: proxyV2Header(0x1, 0x3, unix), remote: "pipe", local: "pipe"},
+		{name: "v2 PROXY unspec", header: proxyV2Header(0x1, 0x0, nil), remote: "pipe", local: "pipe"},
+		{name: "v2 PROXY with TLVs", header: proxyV2Header(0x1, 0x1, withTLV), remote: "192.168.0.1:56324", local: "192.168.0.11:443"},
+		{name: "v2 LOCAL IPv4", header: proxyV2Header(0x0, 0x1, proxyV2IPv4()), remote: "pipe", local: "pipe"},
+		{name: "v2 LOCAL IPv6", header: proxyV2Header(0x0, 0x2, proxyV2IPv6()), remote: "pipe", local: "pipe"},
+		{name: "v2 LOCAL unix", header: proxyV2Header(0x0, 0x3, unix), remote: "pipe", local: "pipe"},
+		{name: "v2 LOCAL unspec", header: proxyV2Header(0x0, 0x0, nil), remote: "pipe", local: "pipe"},
+
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), wantErr: true},
+		{name: "v1 truncated", header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11"), wantErr: true},
+		{name: "v1 oversized", header: []byte("PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n"), wantErr: true},
+		{name: "v1 without CR", header: []byte("PROXY UNKNOWN\n"), wantErr: true},
+		{name: "v1 missing port", header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n"), wantErr: true},
+		{name: "v1 invalid port", header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n"), wantErr: true},
+		{name: "v1 invalid address", header: []byte("PROXY TCP4 192.168.0 192.168.0.11 56324 443\r\n"), wantErr: true},
+		{name: "v1 unknown protocol", header: []byte("PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n"), wantErr: true},
+		{name: "v2 truncated header", header: proxyV2Header(0x1, 0x1, nil)[:14], wantErr: true},
+		{name: "v2 truncated payload", header: proxyV2Header(0x1, 0x1, proxyV2IPv4())[:20], wantErr: true},
+		{name: "v2 short IPv4 payload", header: proxyV2Header(0x1, 0x1, proxyV2IPv4()[:8]), wantErr: true},
+		{name: "v2 short IPv6 payload", header: proxyV2Header(0x1, 0x2, proxyV2IPv4()), wantErr: true},
+		{name: "v2 unknown command", header: proxyV2Header(0x2, 0x1, proxyV2IPv4()), wantErr: true},
+		{name: "v2 unknown version", header: append(append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0, 12), proxyV2IPv4()...), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				client.Write(tt.header)
+				if tt.wantErr {
+					// Truncated headers end with the connection
+					client.Close()
+					return
+				}
+				client.Write([]byte("hello"))
+			}()
+
+			c := &proxyConn{Conn: server, reader: bufio.NewReader(server)}
+			defer c.Close()
+			if got := c.RemoteAddr().String(); !tt.wantErr && got != tt.remote {
+				t.Errorf("RemoteAddr = %s, want %s", got, tt.remote)
+			}
+			if got := c.LocalAddr().String(); !tt.wantErr && got != tt.local {
+				t.Errorf("LocalAddr = %s, want %s", got, tt.local)
+			}
+			buf := make([]byte, 5)
+			_, err := io.ReadFull(c, buf)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %q, want an error", buf)
+				}
+				// The connection is closed, so the peer cannot go on without a valid header
+				if _, err := server.Write([]byte("x")); err == nil {
+					t.Errorf("connection not closed")
+				}
+				return
+			}
+			if err != nil || string(buf) != "hello" {
+				t.Errorf("read %q, %v, want the data after the header", buf, err)
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	const header = "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+	tests := []struct {
+		name    string
+		trusted []string
+		send    string
+		remote  string // "peer" for the address of the peer
+		read    string // the data read, empty if the connection is closed
+	}{
+		{name: "trusted with header", trusted: []string{"127.0.0.1"}, send: header + "hello", remote: "192.168.0.1:56324", read: "hello"},
+		{name: "trusted without header", trusted: []string{"127.0.0.0/8"}, send: "GET / HTTP/1.1\r\n\r\n", remote: "peer"},
+		{name: "untrusted with header", trusted: []string{"10.0.0.0/8"}, send: header, remote: "peer", read: header},
+		{name: "untrusted without header", trusted: []string{"10.0.0.0/8", "::1"}, send: "hello", remote: "peer", read: "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pl, err := newProxyListener(ln, tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pl.Close()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte(tt.send)); err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			want := tt.remote
+			if want == "peer" {
+				want = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr = %s, want %s", got, want)
+			}
+
+			buf := make([]byte, len(tt.read))
+			if tt.read == "" {
+				buf = make([]byte, 1)
+			}
+			n, err := io.ReadFull(conn, buf)
+			if tt.read == "" {
+				if err == nil {
+					t.Fatalf("read %q from a trusted peer without header", buf[:n])
+				}
+				// The peer sees the connection closed
+				client.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if n, err := client.Read(buf); err == nil {
+					t.Errorf("peer read %q, want the connection closed", buf[:n])
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(buf, []byte(tt.read)) {
+				t.Errorf("read %q, %v, want %q", buf, err, tt.read)
+			}
+		})
+	}
+}
+
+func TestNewProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := newProxyListener(ln, nil); err != ErrNoProxyTrustedCIDRs {
+		t.Errorf("no trusted networks = %v, want ErrNoProxyTrustedCIDRs", err)
+	}
+	if _, err := newProxyListener(ln, []string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("invalid network accepted")
+	}
+}
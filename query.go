@@ -1,31 +1,112 @@
 package websvc
 
 import (
+	"net/url"
 	"slices"
+	"strings"
 
 	"github.com/acsl-go/logger"
 	"github.com/gin-gonic/gin"
 )
 
-func parseQuery(c *gin.Context) (map[string]string, string, error) {
-	queries := make(map[string]string)
-	if e := c.BindQuery(&queries); e != nil {
-		logger.Error("Auth: parse query failed", e)
-		return nil, "", e
+// parseQuery parses the query of the request, all the values of repeated keys are kept.
+// As URL.Query does, malformed pairs, e.g. with an invalid escape, are skipped and the others are kept.
+func parseQuery(c *gin.Context) url.Values {
+	values, e := url.ParseQuery(c.Request.URL.RawQuery)
+	if e != nil {
+		logger.Warn("Query: malformed pairs ignored: %v", e)
 	}
-	query_str := ""
+	return values
+}
+
+// flattenQuery keeps the last value of each key, for the handlers taking map[string]string
+func flattenQuery(values url.Values) map[string]string {
+	queries := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			queries[k] = v[len(v)-1]
+		}
+	}
+	return queries
+}
+
+// canonicalQuery builds the query string covered by signatures:
+// keys sorted, the values of a key in the order of the request, keys and values percent-encoded as in RFC 3986,
+// so no key or value can be mistaken for a separator.
+//
+//	?b=2&a=x%26y&a=1  =>  a=x%26y&a=1&b=2
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		ek := escapeRFC3986(k)
+		for _, v := range values[k] {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(ek)
+			sb.WriteByte('=')
+			sb.WriteString(escapeRFC3986(v))
+		}
+	}
+	return sb.String()
+}
 
-	keys := make([]string, 0, len(queries))
-	for k := range queries {
+// legacyQuery builds the query string signed by old clients, the last value of each key unescaped.
+// It returns false if the form is ambiguous, that is a key is repeated or a key or value contains '&' or '=',
+// or ':' which separates the query from the timestamp and the nonce, such a signature could have been made for another request.
+func legacyQuery(values url.Values) (string, bool) {
+	keys := make([]string, 0, len(values))
+	for k, v := range values {
+		if len(v) > 1 || strings.ContainsAny(k, "&=:") {
+			return "", false
+		}
+		for _, s := range v {
+			if strings.ContainsAny(s, "&=:") {
+				return "", false
+			}
+		}
 		keys = append(keys, k)
 	}
 	slices.Sort(keys)
+
+	query_str := ""
 	for _, k := range keys {
-		query_str += k + "=" + queries[k] + "&"
+		query_str += k + "=" + values.Get(k) + "&"
 	}
 	if len(query_str) > 0 && query_str[len(query_str)-1] == '&' {
 		query_str = query_str[:len(query_str)-1]
 	}
+	return query_str, true
+}
 
-	return queries, query_str, nil
+// signedQueries returns the forms of the query a signature is accepted for,
+// the canonical one and, as long as it is unambiguous, the legacy one
+func signedQueries(values url.Values) []string {
+	canonical := canonicalQuery(values)
+	if legacy, ok := legacyQuery(values); ok && legacy != canonical {
+		return []string{canonical, legacy}
+	}
+	return []string{canonical}
+}
+
+func escapeRFC3986(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			sb.WriteByte(ch)
+		} else {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[ch>>4])
+			sb.WriteByte(hex[ch&0x0F])
+		}
+	}
+	return sb.String()
 }
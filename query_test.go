@@ -0,0 +1,89 @@
+package websvc
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseQueryMalformed(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/items?a=1;b=2&c=%zz&d=4&d=5", nil)
+	// The malformed pairs are skipped, as URL.Query does
+	got := parseQuery(c)
+	if want := (url.Values{"d": {"4", "5"}}); canonicalQuery(got) != canonicalQuery(want) {
+		t.Errorf("parseQuery = %v, want %v", got, want)
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"b=2&a=1", "a=1&b=2"},
+		{"b=2&a=x%26y&a=1", "a=x%26y&a=1&b=2"},
+		{"q=hello+world", "q=hello%20world"},
+		{"q=hello%20world&k=%7E-._", "k=~-._&q=hello%20world"},
+		{"a%3Db=1", "a%3Db=1"},
+		{"flag", "flag="},
+		{"n=%E4%B8%AD", "n=%E4%B8%AD"},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.raw)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", tt.raw, err)
+		}
+		if got := canonicalQuery(values); got != tt.want {
+			t.Errorf("canonicalQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestLegacyQuery(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"", "", true},
+		{"b=2&a=1", "a=1&b=2", true},
+		{"q=hello%20world", "q=hello world", true},
+		// Ambiguous forms, the signature could have been made for another query
+		{"a=1&a=2", "", false},
+		{"a=x%26b%3Dy", "", false},
+		{"a%26b=1", "", false},
+		{"a%3Db=1", "", false},
+		{"n:a=1", "", false},
+		{"a=n:1", "", false},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.raw)
+		got, ok := legacyQuery(values)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("legacyQuery(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSignedQueries(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"b=2&a=1", []string{"a=1&b=2"}},
+		{"q=hello%20world", []string{"q=hello%20world", "q=hello world"}},
+		{"a=1&a=2", []string{"a=1&a=2"}},
+		{"a=x%26b%3Dy", []string{"a=x%26b%3Dy"}},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.raw)
+		if got := signedQueries(values); !slices.Equal(got, tt.want) {
+			t.Errorf("signedQueries(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
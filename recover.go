@@ -0,0 +1,145 @@
+package websvc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PanicInfo describes a recovered panic
+type PanicInfo struct {
+	Value     interface{}
+	Stack     []byte
+	Request   *http.Request // The request being handled, nil for websocket callbacks
+	RequestID string
+
+	Connection *WebSocketConnection // The affected connection, nil for HTTP handlers
+	Callback   string               // The websocket callback that panicked, e.g. "OnMessage"
+}
+
+// PanicHook is called for each recovered panic after it has been logged,
+// it could be used to report the panics to an error tracking system
+var PanicHook func(info *PanicInfo)
+
+func reportPanic(info *PanicInfo) {
+	source := "http"
+	if info.Callback != "" {
+		source = "websocket." + info.Callback
+		logger.Error("websvc: panic in websocket %s: %v\n%s", info.Callback, info.Value, info.Stack)
+	} else {
+		logger.Error("websvc: panic in %s %s: %v\n%s", info.Request.Method, info.Request.URL.Path, info.Value, info.Stack)
+	}
+	panicsTotal.With(source).Inc()
+	if PanicHook != nil {
+		// A faulty hook must not take the process down either
+		defer func() {
+			if v := recover(); v != nil {
+				logger.Error("websvc: panic in PanicHook: %v", v)
+			}
+		}()
+		PanicHook(info)
+	}
+}
+
+// Recovery recovers panics of the handlers, logs them with the stack and reports them to PanicHook,
+// then responds 500 with a JSON error body, unless the response has already been started.
+// It is installed by Server and NewHandler.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// http.ErrAbortHandler is the way to abort a response silently, let net/http handle it
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			setSpanError(SpanFromContext(c), fmt.Errorf("panic: %v", v))
+			reportPanic(&PanicInfo{
+				Value:     v,
+				Stack:     debug.Stack(),
+				Request:   c.Request,
+				RequestID: RequestID(c),
+			})
+
+			if isBrokenPipe(v) {
+				c.Error(fmt.Errorf("%v", v))
+				c.Abort()
+				return
+			}
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			writeHTTPError(c, ErrInternal)
+		}()
+		c.Next()
+	}
+}
+
+// isBrokenPipe checks whether the panic is caused by the client going away, nothing can be written then
+func isBrokenPipe(v interface{}) bool {
+	e, ok := v.(error)
+	if !ok {
+		return false
+	}
+	var ne *net.OpError
+	if !errors.As(e, &ne) {
+		return false
+	}
+	var se *os.SyscallError
+	if errors.As(ne, &se) {
+		msg := strings.ToLower(se.Error())
+		return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+	}
+	return false
+}
+
+// _call runs a websocket callback, a panic is recovered and reported, and only this connection is closed
+func (sc *WebSocketConnection) _call(callback string, f func()) bool {
+	return sc._callTraced(callback, nil, f)
+}
+
+// _callTraced is _call for a callback running in a span, a panic also marks the span as failed
+func (sc *WebSocketConnection) _callTraced(callback string, span trace.Span, f func()) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			if span != nil {
+				setSpanError(span, fmt.Errorf("panic: %v", v))
+			}
+			reportPanic(&PanicInfo{
+				Value:      v,
+				Stack:      debug.Stack(),
+				Connection: sc,
+				Callback:   callback,
+			})
+			sc._abort()
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+// _abort closes the underlying connection with 1011 (internal error),
+// the loops of the connection exit and OnDisconnected is called as usual
+func (sc *WebSocketConnection) _abort() {
+	conn := sc._conn
+	if conn == nil {
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error"), time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -1,15 +1,172 @@
 package websvc
 
-import "github.com/gin-gonic/gin"
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response is a response with full control of the content type, headers and body.
+// Handlers may return it by value or by pointer, use the constructors below for the common cases.
+type Response struct {
+	ContentType string
+	Body        []byte
+
+	// [Optional] Additional headers and cookies
+	Headers http.Header
+	Cookies []*http.Cookie
+
+	// [Optional] The body is streamed from Reader instead of Body, it is closed after the response if it is an io.Closer.
+	// ContentLength is sent if it is greater than 0, otherwise the body is chunked.
+	// A Reader also implementing io.Seeker is served with http.ServeContent for 200 responses,
+	// so Range and conditional requests are supported with ModTime.
+	Reader        io.Reader
+	ContentLength int64
+	ModTime       time.Time
+
+	// [Optional] Sets Content-Disposition, so the client saves the body as the file,
+	// or displays it if Inline is true
+	FileName string
+	Inline   bool
+
+	// [Optional] The redirect target, sent as Location, the status code defaults to 302 if it is not 3xx
+	Location string
+}
+
+// NewResponse creates a response of the body
+func NewResponse(contentType string, body []byte) *Response {
+	return &Response{ContentType: contentType, Body: body}
+}
+
+// StreamResponse creates a response streamed from the reader, length could be 0 if unknown
+func StreamResponse(contentType string, reader io.Reader, length int64) *Response {
+	return &Response{ContentType: contentType, Reader: reader, ContentLength: length}
+}
+
+// FileResponse creates a download response of the file, the client saves it as fileName,
+// or as the base name of the path if fileName is empty
+func FileResponse(path string, fileName string) (*Response, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	st, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if fileName == "" {
+		fileName = filepath.Base(path)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Response{
+		ContentType:   contentType,
+		Reader:        f,
+		ContentLength: st.Size(),
+		ModTime:       st.ModTime(),
+		FileName:      fileName,
+	}, nil
+}
+
+// RedirectResponse creates a redirect to the location, return it with 301, 302, 303, 307 or 308
+func RedirectResponse(location string) *Response {
+	return &Response{Location: location}
+}
+
+// SetHeader sets a header of the response
+func (r *Response) SetHeader(key, value string) *Response {
+	if r.Headers == nil {
+		r.Headers = http.Header{}
+	}
+	r.Headers.Set(key, value)
+	return r
+}
+
+// AddCookie adds a cookie to the response
+func (r *Response) AddCookie(cookie *http.Cookie) *Response {
+	r.Cookies = append(r.Cookies, cookie)
+	return r
+}
+
+// Attachment makes the client save the body as the file
+func (r *Response) Attachment(fileName string) *Response {
+	r.FileName = fileName
+	r.Inline = false
+	return r
+}
 
 func processResp(c *gin.Context, code int, rsp interface{}) {
 	if rsp == nil {
 		c.AbortWithStatus(code)
 	} else if r, ok := rsp.(Response); ok {
-		c.Data(code, r.ContentType, r.Body)
+		writeResponse(c, code, &r)
+	} else if r, ok := rsp.(*Response); ok {
+		writeResponse(c, code, r)
 	} else if str, ok := rsp.(string); ok {
 		c.String(code, str)
 	} else {
-		c.JSON(code, rsp)
+		writeData(c, code, rsp)
+	}
+}
+
+func writeResponse(c *gin.Context, code int, r *Response) {
+	if r == nil {
+		c.AbortWithStatus(code)
+		return
+	}
+	if closer, ok := r.Reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	header := c.Writer.Header()
+	for k, vs := range r.Headers {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	for _, cookie := range r.Cookies {
+		http.SetCookie(c.Writer, cookie)
+	}
+	if r.FileName != "" {
+		disposition := "attachment"
+		if r.Inline {
+			disposition = "inline"
+		}
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": r.FileName}))
+	}
+	if r.Location != "" {
+		if code < 300 || code > 399 {
+			code = http.StatusFound
+		}
+		header.Set("Location", r.Location)
+		if r.Body == nil && r.Reader == nil {
+			c.AbortWithStatus(code)
+			return
+		}
+	}
+
+	if r.Reader != nil {
+		if rs, ok := r.Reader.(io.ReadSeeker); ok && code == http.StatusOK {
+			if r.ContentType != "" {
+				header.Set("Content-Type", r.ContentType)
+			}
+			http.ServeContent(c.Writer, c.Request, r.FileName, r.ModTime, rs)
+			return
+		}
+		length := r.ContentLength
+		if length <= 0 {
+			length = -1
+		}
+		c.DataFromReader(code, length, r.ContentType, r.Reader, nil)
+		return
 	}
+	c.Data(code, r.ContentType, r.Body)
 }
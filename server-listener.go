@@ -0,0 +1,186 @@
+package websvc
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acsl-go/logger"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serverListener is one of the listeners of a server, all listeners share the router of the server
+type serverListener struct {
+	config    *Config
+	tlsConfig *tls.Config
+	certStore *CertStore
+	listener  net.Listener
+	server    *http.Server
+	h2server  *http2.Server
+}
+
+// newServerListener binds the listener described by the config
+func newServerListener(config *Config) (*serverListener, error) {
+	l := &serverListener{
+		config: config,
+	}
+
+	if config.IsSSL() {
+		l.tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+
+		switch config.ClientAuthType {
+		case "none":
+			l.tlsConfig.ClientAuth = tls.NoClientCert // No client certificate required
+		case "optional":
+			l.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven // Client certificate is requested but not required, will be verified if provided
+		case "required":
+			l.tlsConfig.ClientAuth = tls.RequireAnyClientCert // Client certificate is required but will not be verified automatically, verification should be done in the application code using the VerifyPeerCertificate callback to allow for custom verification logic (e.g. support for self-signed certificates)
+		case "must":
+			l.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert // Client certificate is required and must be verified by the RootCAs pool, will be verified automatically by the TLS stack using the RootCAs field of the TLS config (which can be set to a custom CA pool if needed)
+		default:
+			l.tlsConfig.ClientAuth = tls.NoClientCert
+			logger.Warn("Invalid client_auth_type: %s, defaulting to 'none'", config.ClientAuthType)
+		}
+
+		// ALPN protocols must be set before the cert store clones the config
+		l.tlsConfig.NextProtos = config.NextProtos
+		if len(l.tlsConfig.NextProtos) == 0 {
+			if config.HTTP2 {
+				l.tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+			} else {
+				l.tlsConfig.NextProtos = []string{"http/1.1"}
+			}
+		}
+
+		var caCerts []string
+		if l.tlsConfig.ClientAuth != tls.NoClientCert {
+			caCerts = config.CACerts
+		}
+
+		// Certificates are served through the store so they can be reloaded without restarting
+		l.certStore = NewCertStore(config.AllCertificates(), caCerts, l.tlsConfig)
+		if err := l.certStore.Reload(); err != nil {
+			return nil, err
+		}
+		l.tlsConfig.GetCertificate = l.certStore.GetCertificate
+		l.tlsConfig.GetConfigForClient = l.certStore.GetConfigForClient
+		l.tlsConfig.ClientCAs = l.certStore.ClientCAs()
+	}
+
+	listener, err := listen(config)
+	if err != nil {
+		logger.Error("websvc:server: listen failed: %v", err)
+		return nil, ErrPortBindingFailed
+	}
+	// The PROXY header comes before the TLS handshake
+	if config.ProxyProtocol {
+		pl, err := newProxyListener(listener, config.ProxyTrustedCIDRs)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = pl
+	}
+	if l.tlsConfig != nil {
+		listener = tls.NewListener(listener, l.tlsConfig)
+	}
+	l.listener = listener
+	return l, nil
+}
+
+// setupServer creates the HTTP server for the listener, the timeouts and limits are taken from the server config
+func (l *serverListener) setupServer(config *Config, handler http.Handler) {
+	readHeaderTimeout := config.ReadHeaderTimeout
+	if readHeaderTimeout == 0 {
+		readHeaderTimeout = 10
+	} else if readHeaderTimeout < 0 {
+		readHeaderTimeout = 0
+	}
+	l.server = &http.Server{
+		Addr:              l.address(),
+		TLSConfig:         l.tlsConfig,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(readHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+
+	l.h2server = &http2.Server{}
+	if l.isTLS() {
+		if slices.Contains(l.tlsConfig.NextProtos, "h2") {
+			if err := http2.ConfigureServer(l.server, l.h2server); err != nil {
+				logger.Error("websvc:server: configure HTTP/2 failed: %v", err)
+			}
+		} else {
+			// A non-nil empty map disables the HTTP/2 support built into net/http
+			l.server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+}
+
+// handler returns the handler to serve on the listener,
+// the exempt paths are served by the router even if the listener redirects to HTTPS
+func (l *serverListener) handler(router http.Handler, httpsPort int, exempt ...string) http.Handler {
+	if l.isTLS() {
+		return router
+	}
+	if l.config.RedirectHTTPS && httpsPort != 0 {
+		return httpsRedirect(httpsPort, router, exempt...)
+	}
+	if l.config.H2C {
+		return h2c.NewHandler(router, l.h2server)
+	}
+	return router
+}
+
+func (l *serverListener) isTLS() bool {
+	return l.tlsConfig != nil
+}
+
+// address returns "host:port" for TCP listeners and the socket path for Unix listeners
+func (l *serverListener) address() string {
+	if addr, ok := l.listener.Addr().(*net.TCPAddr); ok {
+		return net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
+	}
+	return l.listener.Addr().String()
+}
+
+// httpsRedirect redirects all requests to the same host on the given HTTPS port,
+// except the exempt paths, e.g. health checks, which are passed to the next handler
+func httpsRedirect(port int, next http.Handler, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range exempt {
+			if path != "" && r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect // Keep the method and body
+		}
+		http.Redirect(w, r, target, code)
+	})
+}
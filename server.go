@@ -2,11 +2,9 @@ package websvc
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/acsl-go/logger"
@@ -20,107 +18,175 @@ type Server struct {
 	name        string
 	config      *Config
 	initializer ServerInitializer
-	tlsConfig   *tls.Config
-	listener    net.Listener
+	listeners   []*serverListener
 	router      http.Handler
-	server      *http.Server
+	wsConns     *wsRegistry
+	health      *healthRegistry
+	stopping    context.Context // Done once Shutdown is called, ends the streaming responses
+	stop        context.CancelFunc
 
-	Host string // Actual listen host
-	Port int    // Actual listen port, may be different from config if config.Port is 0
-	TLS  bool   // Whether TLS is enabled
+	Host    string // Actual listen host of the primary listener, empty for non-TCP listeners
+	Port    int    // Actual listen port of the primary listener, may be different from config if config.Port is 0, 0 for non-TCP listeners
+	TLS     bool   // Whether TLS is enabled on the primary listener
+	Network string // Actual listen network of the primary listener, e.g. "tcp", "unix"
+
+	// [Optional] Called after the TLS certificates are reloaded, err is nil if the new certificates were applied
+	OnTLSReload func(s *Server, err error)
+
+	// [Optional] The sink of the access log, DefaultAccessLogSink is used if nil
+	AccessLogSink AccessLogSink
 
 	Attachment interface{}
 }
 
 func NewServer(name string, config *Config, initializer ServerInitializer, attachment interface{}) *Server {
+	stopping, stop := context.WithCancel(context.Background())
 	return &Server{
 		name:        name,
 		config:      config,
 		initializer: initializer,
+		wsConns:     newWsRegistry(),
+		health:      newHealthRegistry(),
+		stopping:    stopping,
+		stop:        stop,
 		Attachment:  attachment,
 	}
 }
 
 // Listen starts the server and binds to the specified port. It should be called before starting the service.
+// The primary listener is described by the config itself, additional ones by config.Listeners,
+// if any of them fails to bind, the ones already bound are closed.
 func (s *Server) Listen() error {
 	// Do listen first to catch errors before starting the service
-	if s.config.IsSSL() {
-		s.tlsConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		}
+	configs := []*Config{s.config}
+	for i := range s.config.Listeners {
+		configs = append(configs, &s.config.Listeners[i])
+	}
 
-		cert, _, err := LoadX509KeyPair(s.config.SSLCert, s.config.SSLKey)
+	listeners := make([]*serverListener, 0, len(configs))
+	for _, cfg := range configs {
+		l, err := newServerListener(cfg)
 		if err != nil {
-			return ErrInvalidTlsKeyPair
-		}
-		s.tlsConfig.Certificates = []tls.Certificate{*cert}
-
-		switch s.config.ClientAuthType {
-		case "none":
-			s.tlsConfig.ClientAuth = tls.NoClientCert // No client certificate required
-		case "optional":
-			s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven // Client certificate is requested but not required, will be verified if provided
-		case "required":
-			s.tlsConfig.ClientAuth = tls.RequireAnyClientCert // Client certificate is required but will not be verified automatically, verification should be done in the application code using the VerifyPeerCertificate callback to allow for custom verification logic (e.g. support for self-signed certificates)
-		case "must":
-			s.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert // Client certificate is required and must be verified by the RootCAs pool, will be verified automatically by the TLS stack using the RootCAs field of the TLS config (which can be set to a custom CA pool if needed)
-		default:
-			s.tlsConfig.ClientAuth = tls.NoClientCert
-			logger.Warn("Invalid client_auth_type: %s, defaulting to 'none'", s.config.ClientAuthType)
+			for _, l := range listeners {
+				l.listener.Close()
+			}
+			return err
 		}
-
-		if s.tlsConfig.ClientAuth != tls.NoClientCert {
-			s.tlsConfig.ClientCAs = x509.NewCertPool()
-			for _, ca := range s.config.CACerts {
-				cacert, err := loadData(ca)
-				if err != nil {
-					return fmt.Errorf("failed to load CA certificate: %w", err)
+		if l.certStore != nil {
+			l.certStore.OnReload = func(err error) {
+				if s.OnTLSReload != nil {
+					s.OnTLSReload(s, err)
 				}
-				s.tlsConfig.ClientCAs.AppendCertsFromPEM(cacert)
 			}
 		}
+		listeners = append(listeners, l)
+	}
 
-		listener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", s.config.Host, s.config.Port), s.tlsConfig)
-		if err != nil {
-			return ErrPortBindingFailed
-		}
-
-		s.listener = listener
-		s.TLS = true
-	} else {
-		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", s.config.Host, s.config.Port))
-		if err != nil {
-			return ErrPortBindingFailed
-		}
-		s.listener = listener
-		s.TLS = false
+	// Create the HTTP servers with the router as the handler
+	for _, l := range listeners {
+		l.setupServer(s.config, s.router)
 	}
+	s.listeners = listeners
 
-	// Retrieve the actual port in case it was set to 0 (random)
-	addr := s.listener.Addr().(*net.TCPAddr)
-	s.Host = addr.IP.String()
-	s.Port = addr.Port
+	primary := listeners[0]
+	s.TLS = primary.isTLS()
+	s.Network = primary.listener.Addr().Network()
 
-	// Create the HTTP server with the router as the handler
-	s.server = &http.Server{
-		Addr:      fmt.Sprintf("%s:%d", s.Host, s.Port),
-		TLSConfig: s.tlsConfig,
-		Handler:   s.router,
+	// Retrieve the actual port in case it was set to 0 (random)
+	if addr, ok := primary.listener.Addr().(*net.TCPAddr); ok {
+		s.Host = addr.IP.String()
+		s.Port = addr.Port
 	}
 
 	return nil
 }
 
+// Address returns the actual listen address of the primary listener,
+// "host:port" for TCP listeners and the socket path for Unix listeners
+func (s *Server) Address() string {
+	if len(s.listeners) == 0 {
+		return ""
+	}
+	return s.listeners[0].address()
+}
+
+// Addresses returns the actual listen addresses of all listeners, the primary one first
+func (s *Server) Addresses() []string {
+	addrs := make([]string, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.address())
+	}
+	return addrs
+}
+
+// ReloadTLS reloads the TLS certificates of all listeners immediately.
+// The current certificates are kept if the new ones are invalid.
+func (s *Server) ReloadTLS() error {
+	err := ErrTLSNotEnabled
+	for _, l := range s.listeners {
+		if l.certStore == nil {
+			continue
+		}
+		if e := l.certStore.Reload(); e != nil {
+			return e
+		}
+		err = nil
+	}
+	return err
+}
+
+// Shutdown stops all listeners of the server gracefully, the readiness endpoint starts failing immediately.
+// Load balancers may still route requests to the server until they see it, so the server task waits
+// Config.ShutdownDelay between failing the readiness and calling Shutdown, applications calling Shutdown
+// directly should call SetNotReady and wait as well.
+// Live websocket connections are sent a 1001 (Going Away) close frame, and waited for until
+// their OnDisconnected handlers have run, the remaining ones are force closed when the context is done.
+// Server-Sent Events streams are ended immediately.
 func (s *Server) Shutdown(ctx context.Context) error {
-	if s.server != nil {
-		return s.server.Shutdown(ctx)
+	s.SetNotReady()
+	s.stop()
+	s.wsConns.startDrain()
+	var err error
+	for _, l := range s.listeners {
+		if e := l.server.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
 	}
-	return nil
+	if e := s.wsConns.wait(ctx); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// SetNotReady makes the readiness endpoint fail from now on, as Shutdown does
+func (s *Server) SetNotReady() {
+	s.health.shuttingDown.Store(true)
+}
+
+// AddReadinessCheck registers a named readiness check reported by the readiness endpoint,
+// a check with the same name is replaced. The default timeout is 5 seconds if timeout is 0.
+func (s *Server) AddReadinessCheck(name string, timeout time.Duration, check HealthCheck) {
+	s.health.add(name, timeout, check)
+}
+
+// RemoveReadinessCheck unregisters the named readiness check
+func (s *Server) RemoveReadinessCheck(name string) {
+	s.health.remove(name)
+}
+
+// CheckReadiness runs all readiness checks, the report fails once the server begins shutting down
+func (s *Server) CheckReadiness(ctx context.Context) HealthReport {
+	return s.health.run(ctx)
+}
+
+// WebSocketConnections returns the number of live websocket connections accepted by the server
+func (s *Server) WebSocketConnections() int {
+	return s.wsConns.count()
 }
 
 // Wrap the server's Listen and Serve in one call
 func (s *Server) Start(ctx context.Context) error {
-	if s.server == nil {
+	if s.listeners == nil {
 		err := s.Listen()
 		if err != nil {
 			return err
@@ -137,10 +203,54 @@ func (s *Server) Start(ctx context.Context) error {
 	if logger.Level >= logger.DEBUG {
 		router.Use(gin.Logger())
 	}
+	router.Use(func(c *gin.Context) {
+		c.Set(serverContextKey, s)
+	})
+	if s.config.AccessLog.Enabled {
+		router.Use(AccessLogMiddleware(&s.config.AccessLog, s.AccessLogSink))
+	}
+	if s.config.MaxBodyBytes > 0 {
+		router.Use(BodyLimit(s.config.MaxBodyBytes))
+	}
+	if s.config.Tracing {
+		router.Use(TracingMiddleware())
+	}
+	if s.config.MetricsPath != "" {
+		router.Use(MetricsMiddleware())
+		router.GET(s.config.MetricsPath, MetricsHandler())
+	}
+	// Recover inside the observers, so panics are logged, traced and counted as 500
+	router.Use(Recovery())
+	if s.config.LivenessPath != "" {
+		router.GET(s.config.LivenessPath, s.health.livenessHandler)
+	}
+	if s.config.ReadinessPath != "" {
+		router.GET(s.config.ReadinessPath, s.health.readinessHandler)
+	}
 	s.initializer(ctx, router, s)
+	s.router = router
+
+	// Plain listeners may redirect to the first HTTPS listener
+	httpsPort := 0
+	for _, l := range s.listeners {
+		if addr, ok := l.listener.Addr().(*net.TCPAddr); ok && l.isTLS() {
+			httpsPort = addr.Port
+			break
+		}
+	}
 
-	s.server.Handler = router
-	go s.server.Serve(s.listener)
+	for _, l := range s.listeners {
+		l.server.Handler = l.handler(router, httpsPort, s.config.LivenessPath, s.config.ReadinessPath)
+		go l.server.Serve(l.listener)
+
+		if l.certStore != nil && l.config.CertReloadInterval >= 0 {
+			interval := l.config.CertReloadInterval
+			if interval == 0 {
+				interval = 30
+			}
+			go l.certStore.Watch(ctx, time.Duration(interval)*time.Second)
+		}
+	}
 	return nil
 }
 
@@ -148,33 +258,49 @@ func (s *Server) Start(ctx context.Context) error {
 // The task will attempt to start the server, and if it fails (e.g. due to port binding issues), it will log a warning and retry until it succeeds or the context is canceled.
 func (s *Server) Task(retryDuration time.Duration) service.ITask {
 	return service.NewTask(func(ctx context.Context) {
-		for {
-			err := s.Start(ctx)
-			if err == nil {
-				break // Started successfully
-			}
-			logger.Warn("Failed to start server: %v, will retry in %v", err, retryDuration)
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(retryDuration):
-				// Retry after delay
-			}
+		s.run(ctx, retryDuration)
+	})
+}
+
+// run is the body of the server task, it returns when the server has stopped after ctx is cancelled
+func (s *Server) run(ctx context.Context, retryDuration time.Duration) {
+	for {
+		err := s.Start(ctx)
+		if err == nil {
+			break // Started successfully
 		}
-		if s.TLS {
-			logger.Info("HTTPS server %s started on %s:%d\n", s.name, s.Host, s.Port)
-		} else {
-			logger.Info("HTTP server %s started on %s:%d\n", s.name, s.Host, s.Port)
+		logger.Warn("Failed to start server: %v, will retry in %v", err, retryDuration)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDuration):
+			// Retry after delay
 		}
-		<-ctx.Done()
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := s.server.Shutdown(c); err != nil {
-			logger.Error("Shutdown error:  %+v\n", err)
+	}
+	for _, l := range s.listeners {
+		if l.isTLS() {
+			logger.Info("HTTPS server %s started on %s\n", s.name, l.address())
 		} else {
-			logger.Info("Server %s on %s:%d stopped gracefully\n", s.name, s.Host, s.Port)
+			logger.Info("HTTP server %s started on %s\n", s.name, l.address())
 		}
-	})
+	}
+	<-ctx.Done()
+	if s.config.ShutdownDelay > 0 {
+		s.SetNotReady()
+		logger.Info("Server %s not ready, shutting down in %ds\n", s.name, s.config.ShutdownDelay)
+		time.Sleep(time.Duration(s.config.ShutdownDelay) * time.Second)
+	}
+	timeout := s.config.DrainTimeout
+	if timeout <= 0 {
+		timeout = 5
+	}
+	c, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	if err := s.Shutdown(c); err != nil {
+		logger.Error("Shutdown error:  %+v\n", err)
+	} else {
+		logger.Info("Server %s on %s stopped gracefully\n", s.name, strings.Join(s.Addresses(), ", "))
+	}
 }
 
 // Create a new server task with the given configuration and initializer
@@ -0,0 +1,55 @@
+package websvc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShutdownDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := NewServer("test", &Config{Host: "127.0.0.1", ReadinessPath: "/readyz", ShutdownDelay: 1}, func(ctx context.Context, router *gin.Engine, s *Server) {}, nil)
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.run(ctx, time.Second)
+	}()
+	url := "http://127.0.0.1:" + strconv.Itoa(srv.Port) + "/readyz"
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	ready := func() int {
+		t.Helper()
+		rsp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("readiness request: %v", err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+	// The listener is bound, requests wait until the server is started
+	if code := ready(); code != 200 {
+		t.Fatalf("readiness = %d before shutdown, want 200", code)
+	}
+
+	start := time.Now()
+	cancel()
+	// Still serving during the delay, only the readiness fails
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for ready() != 503 {
+		if time.Now().After(deadline) {
+			t.Fatal("readiness does not fail after the task context is cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	<-stopped
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("server stopped after %v, before the shutdown delay", elapsed)
+	}
+}
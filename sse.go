@@ -0,0 +1,258 @@
+package websvc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// SSEKeepAliveInterval is the interval of the keep-alive comments sent on idle event streams,
+// so proxies do not drop them, 0 disables the keep-alive
+var SSEKeepAliveInterval = 15 * time.Second
+
+// SSEEvent is an event of a Server-Sent Events stream
+type SSEEvent[T interface{}] struct {
+	ID    string        // [Optional] The event ID, sent back by the client as Last-Event-ID when it reconnects
+	Event string        // [Optional] The event type, "message" if empty
+	Data  T             // Strings and []byte are sent as is, other values are encoded as JSON
+	Retry time.Duration // [Optional] The reconnection delay hint for the client
+}
+
+// SSEStream is the event sink of an SSE handler, it is safe to use from multiple goroutines
+type SSEStream[T interface{}] struct {
+	c           *gin.Context
+	ctx         context.Context
+	lastEventID string
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	lastOut time.Time
+}
+
+// Context returns the context of the stream, it is done when the client disconnects or the server shuts down,
+// the handler should return then
+func (s *SSEStream[T]) Context() context.Context {
+	return s.ctx
+}
+
+// Done is a shortcut of Context().Done()
+func (s *SSEStream[T]) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// LastEventID returns the ID of the last event received by the client before it reconnected, empty for a new stream.
+// It is taken from the Last-Event-ID header, or the lastEventId query parameter for clients unable to set headers.
+func (s *SSEStream[T]) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send sends an event to the client
+func (s *SSEStream[T]) Send(ev SSEEvent[T]) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return ErrInvalidSSEField
+	}
+	var sb strings.Builder
+	if ev.ID != "" {
+		sb.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		sb.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		sb.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	data, e := encodeSSEData(ev.Data)
+	if e != nil {
+		return e
+	}
+	// Each line of the data is a data field, the client joins them with "\n"
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+// SendData sends an event of the default type with the data only
+func (s *SSEStream[T]) SendData(data T) error {
+	return s.Send(SSEEvent[T]{Data: data})
+}
+
+// SetRetry tells the client how long to wait before reconnecting
+func (s *SSEStream[T]) SetRetry(d time.Duration) error {
+	return s.write("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n")
+}
+
+// Comment sends a comment, which is ignored by the client
+func (s *SSEStream[T]) Comment(text string) error {
+	var sb strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		sb.WriteString(": " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+func encodeSSEData(data interface{}) (string, error) {
+	switch d := data.(type) {
+	case string:
+		return d, nil
+	case []byte:
+		return string(d), nil
+	}
+	b, e := json.Marshal(data)
+	if e != nil {
+		return "", e
+	}
+	return string(b), nil
+}
+
+func (s *SSEStream[T]) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.ctx.Err() != nil {
+		return ErrStreamClosed
+	}
+	if !s.started {
+		s.started = true
+		header := s.c.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no") // Disable the buffering of nginx
+		s.c.Status(http.StatusOK)
+	}
+	if _, e := s.c.Writer.WriteString(msg); e != nil {
+		s.closed = true
+		return ErrStreamClosed
+	}
+	s.c.Writer.Flush()
+	s.lastOut = time.Now()
+	return nil
+}
+
+// close ends the stream, returns whether the response has been started
+func (s *SSEStream[T]) close() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.started
+}
+
+// keepAlive sends a comment whenever the stream has been idle for the interval
+func (s *SSEStream[T]) keepAlive(interval time.Duration) {
+	tick := time.NewTicker(interval / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-tick.C:
+			s.mu.Lock()
+			idle := time.Since(s.lastOut) >= interval
+			s.mu.Unlock()
+			if idle && s.Comment("keep-alive") == ErrStreamClosed {
+				return
+			}
+		}
+	}
+}
+
+// runSSE runs the handler with a new stream of the request
+func runSSE[T interface{}](c *gin.Context, handler func(*SSEStream[T]) error) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	// End the stream when the server shuts down, http.Server waits for active requests
+	if srv := serverFromContext(c); srv != nil {
+		stop := context.AfterFunc(srv.stopping, cancel)
+		defer stop()
+	}
+	// Streams outlive the write timeout of the server
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	stream := &SSEStream[T]{c: c, ctx: ctx, lastEventID: lastEventID, lastOut: time.Now()}
+	if SSEKeepAliveInterval > 0 {
+		go stream.keepAlive(SSEKeepAliveInterval)
+	}
+
+	e := handler(stream)
+	cancel()
+	started := stream.close()
+
+	if e == nil || e == ErrStreamClosed {
+		return
+	}
+	if started {
+		// Too late for an error response, the stream is just ended
+		logger.Error("Error: %+v", e)
+		c.Error(e)
+	} else {
+		abortWithError(c, e)
+	}
+}
+
+// SSE streams Server-Sent Events to the client, the handler sends events through the stream until it returns.
+// The response is started by the first event, so the handler may still fail the request with an error before.
+func SSE[T interface{}](handler func(*gin.Context, *SSEStream[T]) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		runSSE(c, func(s *SSEStream[T]) error {
+			return handler(c, s)
+		})
+	}
+}
+
+// AuthSSE is SSE authenticated as AuthN
+func AuthSSE[TSES interface{}, T interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES, *SSEStream[T]) error, privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ses_obj, _, code := doAuthN(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+
+		ses, ok := ses_obj.(TSES)
+		if !ok {
+			logger.Fatal("Auth data type miss-match in %s", c.Request.URL.Path)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		runSSE(c, func(s *SSEStream[T]) error {
+			return handler(c, ses, s)
+		})
+	}
+}
+
+// AuthQSSE is SSE authenticated as AuthQN
+func AuthQSSE[TSES interface{}, T interface{}](cfg *AuthenticatorConfigure, handler func(*gin.Context, TSES, map[string]string, *SSEStream[T]) error, privilege string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ses_obj, queries, code := doAuthN(c, cfg, privilege)
+		if code != 0 {
+			c.AbortWithStatus(code)
+			return
+		}
+
+		ses, ok := ses_obj.(TSES)
+		if !ok {
+			logger.Fatal("Auth data type miss-match in %s", c.Request.URL.Path)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		runSSE(c, func(s *SSEStream[T]) error {
+			return handler(c, ses, flattenQuery(queries), s)
+		})
+	}
+}
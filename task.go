@@ -22,6 +22,7 @@ func NewHandler(ctx context.Context, initializer func(context.Context, *gin.Engi
 	if logger.Level >= logger.DEBUG {
 		router.Use(gin.Logger())
 	}
+	router.Use(Recovery())
 
 	initializer(ctx, router)
 	return router
@@ -0,0 +1,121 @@
+package websvc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The instrumentation name of the spans created by the package
+const tracerName = "github.com/acsl-go/websvc"
+
+// Spans are created with the global OpenTelemetry TracerProvider, set it with otel.SetTracerProvider.
+// Until then the provider is a no-op one and tracing costs almost nothing.
+// For tests, a provider exporting to go.opentelemetry.io/otel/sdk/trace/tracetest.NewInMemoryExporter
+// keeps the spans in memory.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// The W3C Trace Context propagator is used unless a global one is set with otel.SetTextMapPropagator
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+func propagator() propagation.TextMapPropagator {
+	if p := otel.GetTextMapPropagator(); len(p.Fields()) > 0 {
+		return p
+	}
+	return defaultPropagator
+}
+
+// ExtractTraceContext returns the context carrying the trace context of the headers, e.g. traceparent,
+// spans started from it become children of the remote span
+func ExtractTraceContext(ctx context.Context, header http.Header) context.Context {
+	return propagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectTraceContext writes the trace context of the span in the context to the headers
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	propagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// requestContext returns the context spans of the request are kept in, gin.Context itself does not carry them
+func requestContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request == nil {
+			return context.Background()
+		}
+		return c.Request.Context()
+	}
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// SpanFromContext returns the current span of the context, a no-op span if there is none.
+// For gin.Context, the span of the request created by TracingMiddleware is returned.
+func SpanFromContext(ctx context.Context) trace.Span {
+	return trace.SpanFromContext(requestContext(ctx))
+}
+
+// StartSpan creates a child span of the current span in the context, it returns the context carrying the new span
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer().Start(requestContext(ctx), name)
+}
+
+// setSpanError marks the span as failed, nil errors are ignored
+func setSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// traceCall runs f in a child span of the current span in the context
+func traceCall(ctx context.Context, name string, f func() error) error {
+	_, span := StartSpan(ctx, name)
+	err := f()
+	setSpanError(span, err)
+	span.End()
+	return err
+}
+
+// TracingMiddleware creates a server span for each request, continuing the trace of the traceparent header.
+// The span is available to handlers through SpanFromContext(c) and c.Request.Context().
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := ExtractTraceContext(c.Request.Context(), c.Request.Header)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("client.address", c.ClientIP()),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d %s", status, http.StatusText(status)))
+		}
+		if len(c.Errors) > 0 {
+			setSpanError(span, c.Errors.Last())
+		}
+	}
+}
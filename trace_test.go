@@ -0,0 +1,75 @@
+package websvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		tp.Shutdown(t.Context())
+	})
+	return exporter
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter := setupTracing(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TracingMiddleware())
+	r.GET("/items/:id", Handler(func(c *gin.Context) (int, interface{}, error) {
+		traceCall(c, "load", func() error { return nil })
+		return 500, nil, nil
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name != "GET /items/:id" || server.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span = %q %v", server.Name, server.SpanKind)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s, want the one of traceparent", got)
+	}
+	if got := server.Parent.SpanID().String(); got != "00f067aa0ba902b7" || !server.Parent.IsRemote() {
+		t.Errorf("server parent = %s, want the remote span of traceparent", got)
+	}
+	if child.Name != "load" || child.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("child span %q is not a child of the server span", child.Name)
+	}
+	if server.Status.Code.String() != "Error" {
+		t.Errorf("server span status = %v, want Error for 500", server.Status.Code)
+	}
+}
+
+func TestInjectTraceContext(t *testing.T) {
+	setupTracing(t)
+	ctx, span := StartSpan(t.Context(), "outgoing")
+	defer span.End()
+
+	header := http.Header{}
+	InjectTraceContext(ctx, header)
+	sc := trace.SpanContextFromContext(ExtractTraceContext(t.Context(), header))
+	if sc.TraceID() != span.SpanContext().TraceID() || sc.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted %v, want %v", sc, span.SpanContext())
+	}
+}
@@ -0,0 +1,194 @@
+package websvc
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Validator is implemented by request types with validation rules beyond the tags,
+// the wrappers call it after the `binding` tags have passed.
+// Return FieldErrors to report specific fields, an HTTPError to respond it as is,
+// any other error is reported as an invalid request with its message.
+type Validator interface {
+	Validate() error
+}
+
+// Validate checks the `binding` tags of the data with the validator of gin, then its Validate method if any.
+// The failures are reported by an HTTPError with status 400 and the FieldErrors as details.
+// Pointers to pointers, e.g. the **Req decoded for a typed handler of *Req, are followed to the request,
+// a nil request, e.g. decoded from a JSON null, is invalid.
+func Validate(data interface{}) error {
+	var errs FieldErrors
+	v := reflect.ValueOf(data)
+	for !v.IsValid() || v.Kind() == reflect.Pointer {
+		if !v.IsValid() || v.IsNil() {
+			errs = append(errs, FieldError{Source: "body", Message: "is required"})
+			return ErrInvalidRequest.WithDetails(errs).Wrap(errs)
+		}
+		if v.Elem().Kind() != reflect.Pointer {
+			break
+		}
+		v = v.Elem()
+	}
+	data = v.Interface()
+	if e := binding.Validator.ValidateStruct(data); e != nil {
+		errs = appendValidationErrors(errs, reflect.TypeOf(data), "", e)
+	}
+	if len(errs) == 0 {
+		if v, ok := data.(Validator); ok {
+			if e := v.Validate(); e != nil {
+				var fe FieldErrors
+				var he *HTTPError
+				if errors.As(e, &fe) {
+					errs = append(errs, fe...)
+				} else if errors.As(e, &he) {
+					return e
+				} else {
+					errs = append(errs, FieldError{Source: "body", Message: e.Error()})
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return ErrInvalidRequest.WithDetails(errs).Wrap(errs)
+	}
+	return nil
+}
+
+func appendValidationErrors(errs FieldErrors, t reflect.Type, prefix string, e error) FieldErrors {
+	var ve validator.ValidationErrors
+	var se binding.SliceValidationError
+	switch {
+	case errors.As(e, &ve):
+		for _, fe := range ve {
+			field, source := validationFieldName(t, fe.StructNamespace())
+			errs = append(errs, FieldError{
+				Field:   prefix + field,
+				Source:  source,
+				Rule:    fe.Tag(),
+				Message: validationMessage(fe),
+			})
+		}
+	case errors.As(e, &se):
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		for i, item := range se {
+			if item != nil {
+				errs = appendValidationErrors(errs, t, prefix+"["+strconv.Itoa(i)+"].", item)
+			}
+		}
+	default:
+		errs = append(errs, FieldError{Source: "body", Message: e.Error()})
+	}
+	return errs
+}
+
+// validationFieldName maps the struct namespace of a failed field, e.g. "Request.Items[0].Name",
+// to the names the client uses, e.g. "items[0].name", and the source of the top level field
+func validationFieldName(t reflect.Type, ns string) (string, string) {
+	parts := strings.Split(ns, ".")
+	if len(parts) > 1 {
+		parts = parts[1:] // The type name
+	}
+	source := "body"
+	names := make([]string, 0, len(parts))
+	for i, part := range parts {
+		name, index, _ := strings.Cut(part, "[")
+		if index != "" {
+			index = "[" + index
+		}
+		for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+		var sf reflect.StructField
+		found := false
+		if t != nil && t.Kind() == reflect.Struct {
+			sf, found = t.FieldByName(name)
+		}
+		if !found {
+			names = append(names, part)
+			t = nil
+			continue
+		}
+		clientName, fieldSource := clientFieldName(sf)
+		if i == 0 {
+			source = fieldSource
+		}
+		names = append(names, clientName+index)
+		t = sf.Type
+	}
+	return strings.Join(names, "."), source
+}
+
+// clientFieldName returns the name of the field in the request and where it comes from
+func clientFieldName(sf reflect.StructField) (string, string) {
+	for _, source := range bindSources {
+		if name, ok := sf.Tag.Lookup(source); ok && name != "" && name != "-" {
+			return name, source
+		}
+	}
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name, "body"
+	}
+	return sf.Name, "body"
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	case "len":
+		return "must have the length " + fe.Param()
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "email":
+		return "must be a valid email address"
+	}
+	if fe.Param() != "" {
+		return "failed the rule " + fe.Tag() + "=" + fe.Param()
+	}
+	return "failed the rule " + fe.Tag()
+}
+
+// readBody reads the whole request body, the errors are HTTPErrors
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, e := io.ReadAll(c.Request.Body)
+	if e != nil {
+		if isBodyTooLarge(e) {
+			return nil, ErrPayloadTooLarge.Wrap(e)
+		}
+		return nil, ErrBadRequest.Wrap(e)
+	}
+	return body, nil
+}
+
+// decodeBody decodes the body into data with the codec of its Content-Type and validates it, the errors are HTTPErrors
+func decodeBody(c *gin.Context, body []byte, data interface{}) error {
+	cd, e := requestCodec(c)
+	if e != nil {
+		return e
+	}
+	if e := cd.Unmarshal(body, data); e != nil {
+		return ErrInvalidRequest.WithDetails(FieldErrors{bodyFieldError(e)}).Wrap(e)
+	}
+	return Validate(data)
+}
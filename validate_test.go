@@ -0,0 +1,67 @@
+package websvc
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type validatedRequest struct {
+	Name string `json:"name" binding:"required"`
+	Min  int    `json:"min"`
+	Max  int    `json:"max"`
+}
+
+func (r *validatedRequest) Validate() error {
+	if r.Min > r.Max {
+		return FieldErrors{{Field: "min", Source: "body", Message: "must not exceed max"}}
+	}
+	return nil
+}
+
+func TestValidatePointerRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/items", HandlerD(func(c *gin.Context, req *validatedRequest) (int, interface{}, error) {
+		return 200, req.Name, nil
+	}))
+
+	tests := []struct {
+		name  string
+		body  string
+		want  int
+		field string
+	}{
+		{name: "valid", body: `{"name":"a","min":1,"max":2}`, want: 200},
+		{name: "binding tag", body: `{"min":1,"max":2}`, want: 400, field: "name"},
+		{name: "Validate method", body: `{"name":"a","min":3,"max":2}`, want: 400, field: "min"},
+		{name: "null", body: `null`, want: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/items", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.field != "" && !strings.Contains(w.Body.String(), `"field":"`+tt.field+`"`) {
+				t.Errorf("body %s does not report the field %q", w.Body.String(), tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateNil(t *testing.T) {
+	var req *validatedRequest
+	for _, data := range []interface{}{nil, req, &req} {
+		var he *HTTPError
+		if e := Validate(data); !errors.As(e, &he) || he.Status != 400 {
+			t.Errorf("Validate(%#v) = %v, want 400", data, e)
+		}
+	}
+}
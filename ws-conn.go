@@ -14,6 +14,8 @@ import (
 	"github.com/acsl-go/logger"
 	"github.com/acsl-go/misc"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/proxy"
 )
 
@@ -30,6 +32,9 @@ type WebSocketConnection struct {
 	_refCount int32
 
 	_running bool
+
+	_trace trace.SpanContext          // Parent of the message spans, from the upgrade request or the outgoing connect
+	_span  atomic.Pointer[trace.Span] // The span of the message being dispatched, read by Context from any goroutine
 }
 
 func NewWebSocketConnection(cfg *WebSocketConfig) *WebSocketConnection {
@@ -88,7 +93,7 @@ func (sc *WebSocketConnection) Connect(ctx context.Context, url string, timeout
 		if err != nil {
 			logger.Error("websvc:ws:dial %s failed: %s", url, err.Error())
 			if sc._cfg.OnDisconnected != nil {
-				sc._cfg.OnDisconnected(sc, sc._cfg.Attachment)
+				sc._call("OnDisconnected", func() { sc._cfg.OnDisconnected(sc, sc._cfg.Attachment) })
 			}
 			return sc._running
 		}
@@ -121,7 +126,7 @@ func (sc *WebSocketConnection) Connect(ctx context.Context, url string, timeout
 			if err != nil {
 				logger.Error("websvc:ws:dial %s failed: %s", url, err.Error())
 				if sc._cfg.OnDisconnected != nil {
-					sc._cfg.OnDisconnected(sc, sc._cfg.Attachment)
+					sc._call("OnDisconnected", func() { sc._cfg.OnDisconnected(sc, sc._cfg.Attachment) })
 				}
 				return sc._running
 			}
@@ -129,19 +134,35 @@ func (sc *WebSocketConnection) Connect(ctx context.Context, url string, timeout
 		}
 	}
 
+	// Propagate the trace context to the remote side
+	spanCtx, span := tracer().Start(requestContext(ctx), "websocket.Connect",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("websocket.url", url)))
+	if span.SpanContext().IsValid() {
+		if headers == nil {
+			headers = http.Header{}
+		} else {
+			headers = headers.Clone()
+		}
+		InjectTraceContext(spanCtx, headers)
+	}
+	sc._trace = span.SpanContext()
+
 	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	conn, _, e := dialer.DialContext(timeoutCtx, url, headers)
+	setSpanError(span, e)
+	span.End()
 	if e != nil {
 		logger.Error("websvc:ws:dial %s failed: %s", url, e.Error())
 		if sc._cfg.OnDisconnected != nil {
-			sc._cfg.OnDisconnected(sc, sc._cfg.Attachment)
+			sc._call("OnDisconnected", func() { sc._cfg.OnDisconnected(sc, sc._cfg.Attachment) })
 		}
 		return sc._running
 	} else {
 		sc._conn = conn
 		if sc._cfg.OnConnected != nil {
-			sc._cfg.OnConnected(sc, sc._cfg.Attachment)
+			sc._call("OnConnected", func() { sc._cfg.OnConnected(sc, sc._cfg.Attachment) })
 		}
 		sc.run(ctx)
 		return sc._running
@@ -156,6 +177,16 @@ func (sc *WebSocketConnection) Close() {
 	}
 }
 
+// CloseWithCode sends a close frame with the given code and reason to the peer,
+// the connection will be closed once the peer replies, OnDisconnected is called as usual.
+func (sc *WebSocketConnection) CloseWithCode(code int, reason string) error {
+	conn := sc._conn
+	if conn == nil {
+		return nil
+	}
+	return conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
 // 断开，还会重新连接
 func (sc *WebSocketConnection) Disconnect() {
 	if sc._conn != nil {
@@ -173,7 +204,7 @@ func (sc *WebSocketConnection) Send(msg *misc.Buffer) {
 	if msg.Tag < 0 || msg.Tag > 2 {
 		msg.Tag = 0
 	}
-	sc._sendingQueue <- msg
+	sc._enqueue(msg)
 }
 
 func (sc *WebSocketConnection) SendBinaryBuffer(msg *misc.Buffer) {
@@ -182,7 +213,7 @@ func (sc *WebSocketConnection) SendBinaryBuffer(msg *misc.Buffer) {
 		return
 	}
 	msg.Tag = websocket.BinaryMessage
-	sc._sendingQueue <- msg
+	sc._enqueue(msg)
 }
 
 func (sc *WebSocketConnection) SendTextBuffer(msg *misc.Buffer) {
@@ -191,7 +222,7 @@ func (sc *WebSocketConnection) SendTextBuffer(msg *misc.Buffer) {
 		return
 	}
 	msg.Tag = websocket.TextMessage
-	sc._sendingQueue <- msg
+	sc._enqueue(msg)
 }
 
 func (sc *WebSocketConnection) SendBytes(data []byte) {
@@ -221,6 +252,16 @@ func (sc *WebSocketConnection) SendJson(data interface{}) {
 	sc.SendTextBuffer(buf)
 }
 
+// Context returns a context carrying the span of the message being dispatched to OnMessage,
+// so the handler can create child spans with StartSpan.
+// Outside of OnMessage, the context carries no span, but StartSpan continues the trace of the connection.
+func (sc *WebSocketConnection) Context() context.Context {
+	if span := sc._span.Load(); span != nil {
+		return trace.ContextWithSpan(context.Background(), *span)
+	}
+	return trace.ContextWithRemoteSpanContext(context.Background(), sc._trace)
+}
+
 func (sc *WebSocketConnection) IsConnected() bool {
 	return sc._conn != nil
 }
@@ -242,12 +283,13 @@ func (sc *WebSocketConnection) GetRemoteAddr() net.Addr {
 func (sc *WebSocketConnection) run(ctx context.Context) {
 	// Check the connection object, upper layer may have closed the connection in the onConnected callback.
 	if sc._conn != nil {
+		wsConnections.Inc()
 		sc._lastBeat = time.Now().UnixMilli()
 		sc._conn.SetPingHandler(func(appData string) error {
 			sc._lastBeat = time.Now().UnixMilli()
 			buf := sc._alloc_buffer()
 			buf.Tag = websocket.PongMessage
-			sc._sendingQueue <- buf
+			sc._enqueue(buf)
 			return nil
 		})
 		sc._conn.SetPongHandler(func(appData string) error {
@@ -275,13 +317,15 @@ func (sc *WebSocketConnection) run(ctx context.Context) {
 			sc._conn.Close()
 			sc._conn = nil
 		}
+		wsConnections.Dec()
 	}
 	if sc._cfg.OnDisconnected != nil {
-		sc._cfg.OnDisconnected(sc, sc._cfg.Attachment)
+		sc._call("OnDisconnected", func() { sc._cfg.OnDisconnected(sc, sc._cfg.Attachment) })
 	}
 	for {
 		select {
 		case wm := <-sc._sendingQueue:
+			wsSendingQueue.Dec()
 			wm.Release()
 		case <-sc._quitChan:
 			// DO NOTHING
@@ -317,20 +361,23 @@ func (sc *WebSocketConnection) beatLoop() {
 		case <-tick.C:
 			ts := time.Now().UnixMilli()
 			if sc._cfg.OnBeat != nil {
-				sc._cfg.OnBeat(sc, sc._cfg.Attachment)
+				if !sc._call("OnBeat", func() { sc._cfg.OnBeat(sc, sc._cfg.Attachment) }) {
+					return // The connection is closed, recvLoop will signal the others
+				}
 			}
 
 			if ts >= nextPing {
 				buf := sc._alloc_buffer()
 				buf.Tag = websocket.PingMessage
-				sc._sendingQueue <- buf
+				sc._enqueue(buf)
 				if sc._cfg.OnSendPing != nil {
-					sc._cfg.OnSendPing(sc, sc._cfg.Attachment)
+					sc._call("OnSendPing", func() { sc._cfg.OnSendPing(sc, sc._cfg.Attachment) })
 				}
 				nextPing = ts + int64(sc._cfg.PingInterval)
 			}
 
 			if ts-sc._lastBeat > beatTimout {
+				wsBeatTimeouts.Inc()
 				sc._quitChan <- 1
 				return
 			}
@@ -347,6 +394,7 @@ func (sc *WebSocketConnection) sendLoop() {
 			sc._quitChan <- s
 			return
 		case buf := <-sc._sendingQueue:
+			wsSendingQueue.Dec()
 			if buf.Tag == websocket.PingMessage {
 				conn.WriteMessage(websocket.PingMessage, nil)
 			} else if buf.Tag == websocket.PongMessage {
@@ -355,11 +403,14 @@ func (sc *WebSocketConnection) sendLoop() {
 				if buf.Tag == 0 {
 					buf.Tag = websocket.TextMessage
 				}
-				err := conn.WriteMessage(buf.Tag, buf.Bytes())
+				data := buf.Bytes()
+				err := conn.WriteMessage(buf.Tag, data)
 				if err != nil {
 					buf.Release()
 					return
 				}
+				wsMessagesOut.Inc()
+				wsBytesOut.Add(float64(len(data)))
 			}
 			buf.Release()
 		}
@@ -391,13 +442,30 @@ func (sc *WebSocketConnection) recvLoop() {
 		}
 		msg.SetDataLen(p)
 		msg.Seek(0, 0)
+		wsMessagesIn.Inc()
+		wsBytesIn.Add(float64(p))
 		if sc._cfg.OnMessage != nil {
-			sc._cfg.OnMessage(sc, mt, msg.AddRef(), sc._cfg.Attachment)
+			_, span := tracer().Start(trace.ContextWithRemoteSpanContext(context.Background(), sc._trace), "websocket.OnMessage",
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.Int("websocket.message_type", mt),
+					attribute.Int("websocket.message_size", p),
+				))
+			sc._span.Store(&span)
+			// A panic closes the connection, so the next read fails and the loop exits
+			sc._callTraced("OnMessage", span, func() { sc._cfg.OnMessage(sc, mt, msg.AddRef(), sc._cfg.Attachment) })
+			sc._span.Store(nil)
+			span.End()
 		}
 		msg.Release()
 	}
 }
 
+func (sc *WebSocketConnection) _enqueue(buf *misc.Buffer) {
+	wsSendingQueue.Inc()
+	sc._sendingQueue <- buf
+}
+
 func (sc *WebSocketConnection) _alloc_buffer() *misc.Buffer {
 	var buf *misc.Buffer
 	if sc._cfg.BufferPool != nil {
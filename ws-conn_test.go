@@ -0,0 +1,79 @@
+package websvc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acsl-go/misc"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestWebSocketMessageSpan(t *testing.T) {
+	exporter := setupTracing(t)
+	gin.SetMode(gin.TestMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	r := gin.New()
+	r.GET("/ws", WebSocketHandler(ctx, &WebSocketConfig{
+		BufferSize: 1024,
+		OnConnected: func(conn *WebSocketConnection, attachment interface{}) {
+			// Context may be called from other goroutines while a message is dispatched
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						conn.Context()
+					}
+				}
+			}()
+		},
+		OnMessage: func(conn *WebSocketConnection, msgType int, msg *misc.Buffer, attachment interface{}) {
+			defer msg.Release()
+			_, span := StartSpan(conn.Context(), "handle")
+			span.End()
+			panic("boom")
+		},
+	}))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	defer close(done)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	// The panic closes the connection
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("connection not closed after the panic")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.GetSpans()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child, msgSpan := spans[0], spans[1]
+	if msgSpan.Name != "websocket.OnMessage" || msgSpan.Status.Code != codes.Error {
+		t.Errorf("message span = %q %v, want an error for the panic", msgSpan.Name, msgSpan.Status.Code)
+	}
+	if child.Parent.SpanID() != msgSpan.SpanContext.SpanID() {
+		t.Errorf("span %q is not a child of the message span", child.Name)
+	}
+}
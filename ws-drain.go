@@ -0,0 +1,120 @@
+package websvc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/acsl-go/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const serverContextKey = "websvc.server"
+
+// How long wait waits for the connections to end after force closing them
+const wsForceCloseTimeout = 5 * time.Second
+
+// wsRegistry tracks the live websocket connections accepted by a server,
+// hijacked connections are not tracked by http.Server so they have to be drained separately.
+type wsRegistry struct {
+	mu       sync.Mutex
+	conns    map[*WebSocketConnection]struct{}
+	wg       sync.WaitGroup
+	draining bool
+
+	// Connections are run with this context instead of the handler context,
+	// so they are only force closed after the drain timeout
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newWsRegistry() *wsRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &wsRegistry{
+		conns:  make(map[*WebSocketConnection]struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// add registers a connection, returns false if the server is shutting down
+func (r *wsRegistry) add(conn *WebSocketConnection) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	r.wg.Add(1)
+	return true
+}
+
+// remove unregisters a connection, it should be called after OnDisconnected has been processed
+func (r *wsRegistry) remove(conn *WebSocketConnection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.conns[conn]; ok {
+		delete(r.conns, conn)
+		r.wg.Done()
+	}
+}
+
+func (r *wsRegistry) isDraining() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+// startDrain rejects new connections and asks all live connections to close
+func (r *wsRegistry) startDrain() {
+	r.mu.Lock()
+	r.draining = true
+	conns := make([]*WebSocketConnection, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.CloseWithCode(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// wait blocks until all connections are closed, the remaining connections are force closed
+// when the context is done, and given up after wsForceCloseTimeout.
+func (r *wsRegistry) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		select {
+		case <-done:
+		case <-time.After(wsForceCloseTimeout):
+			logger.Error("WebSocket: %d connections still open after force close", r.count())
+		}
+		return ctx.Err()
+	}
+}
+
+// count returns the number of live connections
+func (r *wsRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+func serverFromContext(c *gin.Context) *Server {
+	if v, ok := c.Get(serverContextKey); ok {
+		if s, ok := v.(*Server); ok {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package websvc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketGoingAwayOnTaskStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := NewServer("test", &Config{Host: "127.0.0.1", DrainTimeout: 2}, func(ctx context.Context, router *gin.Engine, s *Server) {
+		// The handler holds the application context, as initializers usually pass it on
+		router.GET("/ws", WebSocketHandler(ctx, &WebSocketConfig{BufferSize: 1024}))
+	}, nil)
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.run(ctx, time.Second)
+	}()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:"+strconv.Itoa(srv.Port)+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for srv.WebSocketConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+		t.Fatalf("read after the task stopped = %v, want close 1001", err)
+	}
+	conn.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	if n := srv.WebSocketConnections(); n != 0 {
+		t.Errorf("%d connections left after shutdown", n)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/acsl-go/service"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewConnectionPool() *sync.Pool {
@@ -61,12 +62,21 @@ func WebSocketHandler(ctx context.Context, cfg *WebSocketConfig) gin.HandlerFunc
 		},
 	}
 	return func(c *gin.Context) {
+		// Connections accepted by a Server are tracked, so they can be drained on shutdown
+		var registry *wsRegistry
+		if srv := serverFromContext(c); srv != nil {
+			registry = srv.wsConns
+			if registry.isDraining() {
+				c.AbortWithStatus(503)
+				return
+			}
+		}
+
 		var connectionAttachment interface{}
 		if cfg.BeforeUpgrade != nil {
 			code, data, err := cfg.BeforeUpgrade(c, cfg.Attachment)
 			if err != nil {
-				logger.Error("Error: %+v", err)
-				c.AbortWithStatus(500)
+				abortWithError(c, err)
 				return
 			} else if code != 0 {
 				processResp(c, code, data)
@@ -90,6 +100,10 @@ func WebSocketHandler(ctx context.Context, cfg *WebSocketConfig) gin.HandlerFunc
 
 		cli.Attachment = connectionAttachment
 		cli._conn = conn
+		cli._trace = SpanFromContext(c).SpanContext()
+		if !cli._trace.IsValid() {
+			cli._trace = trace.SpanContextFromContext(ExtractTraceContext(context.Background(), c.Request.Header))
+		}
 		cli._pool = cfg.ConnectionPool
 		cli._refCount = 1
 		cli._cfg = cfg
@@ -106,10 +120,41 @@ func WebSocketHandler(ctx context.Context, cfg *WebSocketConfig) gin.HandlerFunc
 			cli._sendingQueue = make(chan *misc.Buffer, sendingQueueSize)
 		}
 
+		runCtx := ctx
+		stopGoingAway := func() {}
+		if registry != nil {
+			if !registry.add(cli) {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+				conn.Close()
+				cli._conn = nil
+				cli.Release()
+				return
+			}
+			// Only the server force closes the connection, after the drain timeout.
+			// The application context is cancelled when the server task stops, it asks the peer to close as the drain does,
+			// so the peer gets 1001 rather than a dropped connection.
+			runCtx = registry.ctx
+			closed := make(chan struct{})
+			stop := context.AfterFunc(ctx, func() {
+				defer close(closed)
+				cli.CloseWithCode(websocket.CloseGoingAway, "server shutting down")
+			})
+			stopGoingAway = func() {
+				if !stop() {
+					<-closed
+				}
+			}
+		}
+
 		if cfg.OnConnected != nil {
-			cfg.OnConnected(cli, cfg.Attachment)
+			cli._call("OnConnected", func() { cfg.OnConnected(cli, cfg.Attachment) })
+		}
+		cli.run(runCtx)
+		stopGoingAway()
+		// Unregistered before the object goes back to the pool, where another upgrade may take it
+		if registry != nil {
+			registry.remove(cli)
 		}
-		cli.run(ctx)
 		cli.Release()
 
 	}